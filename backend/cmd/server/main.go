@@ -49,16 +49,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Redis bus for WS fanout
-	bus, err := ws.NewRedisBus(ctx, cfg, logger)
+	// Fold the per-update log into document snapshots in the background
+	go pg.RunCompactor(ctx, cfg.CompactEvery, cfg.CompactAfter)
+
+	// Cross-instance bus for WS fanout (BUS_DRIVER picks the backend)
+	bus, err := ws.NewBus(ctx, cfg, logger)
 	if err != nil {
-		logger.Error("redis connect", "err", err)
+		logger.Error("bus connect", "driver", cfg.BusDriver, "err", err)
 		log.Fatal(err)
 	}
 	defer bus.Close()
 
 	// WebSocket hub
-	hub := ws.NewHub(logger, bus, pg)
+	hub := ws.NewHub(cfg, logger, bus, pg)
 	go hub.Run(ctx)
 
 	// HTTP + WS router
@@ -82,9 +85,13 @@ func main() {
 	<-ctx.Done()
 	logger.Info("server.shutdown.start")
 
-	// shutdown
+	// shutdown: drain WebSockets first (srv.Shutdown doesn't track hijacked
+	// connections) so pending saves land before the DB pool closes
 	shutdownCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
 	defer stop()
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		logger.Error("ws.drain", "err", err)
+	}
 	_ = srv.Shutdown(shutdownCtx)
 
 	logger.Info("server.shutdown.complete")
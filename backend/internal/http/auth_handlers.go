@@ -89,6 +89,20 @@ func (a *AuthAPI) Me(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"userId": uid})
 }
 
+// WSTicket issues a 30s ticket for opening a WebSocket as the current user
+func (a *AuthAPI) WSTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	tok, err := a.JWT.SignTicket(auth.UserID(r.Context()), 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"ticket": tok})
+}
+
 // send JSON with proper headers
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -2,25 +2,47 @@ package httpx
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"realtime-docs/internal/store"
+	"realtime-docs/internal/ws"
 	"realtime-docs/pkg/auth"
 )
 
-type DocsAPI struct{ DB *store.Postgres }
+type DocsAPI struct {
+	DB  *store.Postgres
+	Hub *ws.Hub
+	JWT *auth.JWT // signs share link tickets
+
+	Proxies Proxies // trusted for visitors' addresses in share link uses
+}
 
 type createDocReq struct {
 	Title string `json:"title"`
 }
 
 type docResponse struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Version   int64      `json:"version"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Role      store.Role `json:"role,omitempty"`
+}
+
+type presenceResponse struct {
+	DocID string        `json:"docId"`
+	Count int           `json:"count"` // distinct users
+	Users []ws.Presence `json:"users"`
+}
+
+type versionResponse struct {
 	Version   int64     `json:"version"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // Create handles new doc creation for the authenticated user.
@@ -40,13 +62,13 @@ func (a *DocsAPI) Create(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(docResponse{
-		ID: d.ID, Title: d.Title, Version: d.Version, UpdatedAt: d.UpdatedAt,
+		ID: d.ID, Title: d.Title, Version: d.Version, UpdatedAt: d.UpdatedAt, Role: d.Role,
 	})
 }
 
-// List returns up to 100 docs
+// List returns up to 100 docs the user has a role on
 func (a *DocsAPI) List(w http.ResponseWriter, r *http.Request) {
-	docs, err := a.DB.ListDocs(r.Context(), 100, 0)
+	docs, err := a.DB.ListDocs(r.Context(), auth.UserID(r.Context()), 100, 0)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -55,7 +77,7 @@ func (a *DocsAPI) List(w http.ResponseWriter, r *http.Request) {
 	resp := make([]docResponse, 0, len(docs))
 	for _, d := range docs {
 		resp = append(resp, docResponse{
-			ID: d.ID, Title: d.Title, Version: d.Version, UpdatedAt: d.UpdatedAt,
+			ID: d.ID, Title: d.Title, Version: d.Version, UpdatedAt: d.UpdatedAt, Role: d.Role,
 		})
 	}
 
@@ -63,15 +85,18 @@ func (a *DocsAPI) List(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// Get streams a doc's raw bytes and version header.
+// Get streams a doc's raw bytes (snapshot + logged updates) and version header.
 func (a *DocsAPI) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
 		http.Error(w, "id required", http.StatusBadRequest)
 		return
 	}
+	if _, ok := a.authorize(w, r, id, store.RoleViewer); !ok {
+		return
+	}
 
-	d, err := a.DB.GetDoc(r.Context(), id)
+	d, err := a.DB.LoadDoc(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -80,4 +105,121 @@ func (a *DocsAPI) Get(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("X-Doc-Version", fmt.Sprintf("%d", d.Version))
 	_, _ = w.Write(d.Bytes)
-}
\ No newline at end of file
+}
+
+// ListVersions returns the kept snapshots of a doc, newest first
+func (a *DocsAPI) ListVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := a.authorize(w, r, r.PathValue("id"), store.RoleViewer); !ok {
+		return
+	}
+	vs, err := a.DB.ListVersions(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]versionResponse, 0, len(vs))
+	for _, v := range vs {
+		resp = append(resp, versionResponse{Version: v.Version, Size: v.Size, CreatedAt: v.CreatedAt})
+	}
+	writeJSON(w, resp)
+}
+
+// GetVersion streams the raw bytes of one kept snapshot
+func (a *DocsAPI) GetVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := a.authorize(w, r, r.PathValue("id"), store.RoleViewer); !ok {
+		return
+	}
+	v, err := strconv.ParseInt(r.PathValue("v"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad version", http.StatusBadRequest)
+		return
+	}
+
+	ver, err := a.DB.GetVersion(r.Context(), r.PathValue("id"), v)
+	if err != nil {
+		writeVersionErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Doc-Version", fmt.Sprintf("%d", ver.Version))
+	_, _ = w.Write(ver.Bytes)
+}
+
+// RestoreVersion makes a kept snapshot the current doc and resyncs live editors
+func (a *DocsAPI) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := a.authorize(w, r, r.PathValue("id"), store.RoleEditor); !ok {
+		return
+	}
+	v, err := strconv.ParseInt(r.PathValue("v"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad version", http.StatusBadRequest)
+		return
+	}
+
+	d, err := a.Hub.Restore(r.Context(), r.PathValue("id"), v)
+	if err != nil {
+		writeVersionErr(w, err)
+		return
+	}
+	writeJSON(w, docResponse{ID: d.ID, Title: d.Title, Version: d.Version, UpdatedAt: d.UpdatedAt})
+}
+
+// Presence returns who has the doc open, across all instances
+func (a *DocsAPI) Presence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := a.authorize(w, r, id, store.RoleViewer); !ok {
+		return
+	}
+	users, err := a.Hub.Presence(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, presenceResponse{DocID: id, Count: len(users), Users: users})
+}
+
+// authorize checks the caller's role on doc id. Docs they have no role on
+// are reported as missing so IDs don't leak; too low a role is a 403.
+func (a *DocsAPI) authorize(w http.ResponseWriter, r *http.Request, id string, min store.Role) (store.Role, bool) {
+	role, err := a.DB.DocRole(r.Context(), id, auth.UserID(r.Context()))
+	if err != nil && !errors.Is(err, store.ErrDocNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if role == "" {
+		http.Error(w, store.ErrDocNotFound.Error(), http.StatusNotFound)
+		return "", false
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return role, true
+}
+
+// writeVersionErr maps store errors of the version endpoints to HTTP statuses
+func writeVersionErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrVersionNotFound) || errors.Is(err, store.ErrDocNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
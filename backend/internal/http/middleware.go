@@ -22,7 +22,7 @@ func NewMiddleware(cfg app.Config) *Middleware {
 	return &Middleware{
 		cors: cors.New(cors.Options{
 			AllowedOrigins:   cfg.CORSAllow,
-			AllowedMethods:   []string{"GET", "POST", "PUT", "OPTIONS"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 			AllowedHeaders:   []string{"*"},
 			AllowCredentials: true,
 		}),
@@ -36,6 +36,63 @@ func (m *Middleware) Wrap(h http.Handler) http.Handler {
 	return m.cors.Handler(m.rlimit.Middleware(h))
 }
 
+// WSAuth authenticates a WebSocket handshake. Browsers can't set headers on
+// upgrades, so the JWT comes as a "bearer.<jwt>" subprotocol or as a
+// short-lived ?ticket= from POST /api/ws-ticket. Visitors without an account
+// pass ?share= with the ticket from GET /api/share/{token} instead.
+func (m *Middleware) WSAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var uid string
+		var err error
+		if tok := bearerSubprotocol(r); tok != "" {
+			uid, err = m.auth.Verify(tok)
+		} else if t := r.URL.Query().Get("ticket"); t != "" {
+			uid, err = m.auth.VerifyTicket(t)
+		} else if s := r.URL.Query().Get("share"); s != "" {
+			linkID, err := m.auth.VerifyShareTicket(s)
+			if err != nil {
+				http.Error(w, "bad share ticket", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithShareLink(r.Context(), linkID)))
+			return
+		} else {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), uid)))
+	})
+}
+
+// bearerSubprotocol returns the token of a "bearer.<jwt>" subprotocol offer
+func bearerSubprotocol(r *http.Request) string {
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if tok, ok := strings.CutPrefix(strings.TrimSpace(p), "bearer."); ok {
+				return tok
+			}
+		}
+	}
+	return ""
+}
+
+// OptionalAuth adds the user ID to the context when a valid JWT is sent,
+// and lets anonymous requests through
+func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if uid, err := m.auth.Verify(tok); err == nil {
+				r = r.WithContext(auth.WithUser(r.Context(), uid))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Auth enforces JWT auth and adds user ID to the request context
 func (m *Middleware) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
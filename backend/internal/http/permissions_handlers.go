@@ -0,0 +1,125 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"realtime-docs/internal/store"
+)
+
+type permissionReq struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type permissionResponse struct {
+	UserID    string     `json:"userId"`
+	Email     string     `json:"email"`
+	Role      store.Role `json:"role"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+func toPermissionResponse(p store.Permission) permissionResponse {
+	return permissionResponse{UserID: p.UserID, Email: p.Email, Role: p.Role, UpdatedAt: p.UpdatedAt}
+}
+
+// Permissions lists (GET, any role) or grants (POST, owner) roles on a doc
+func (a *DocsAPI) Permissions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := a.authorize(w, r, id, store.RoleViewer); !ok {
+			return
+		}
+		ps, err := a.DB.ListPermissions(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp := make([]permissionResponse, 0, len(ps))
+		for _, p := range ps {
+			resp = append(resp, toPermissionResponse(p))
+		}
+		writeJSON(w, resp)
+
+	case http.MethodPost:
+		if _, ok := a.authorize(w, r, id, store.RoleOwner); !ok {
+			return
+		}
+		var req permissionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		role, ok := store.ParseGrantable(req.Role)
+		if !ok {
+			http.Error(w, "role must be editor, commenter or viewer", http.StatusBadRequest)
+			return
+		}
+		p, err := a.DB.GrantPermission(r.Context(), id, req.Email, role)
+		if err != nil {
+			writePermissionErr(w, err)
+			return
+		}
+		writeJSON(w, toPermissionResponse(p))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Permission changes (PUT) or revokes (DELETE) one user's role; owner only
+func (a *DocsAPI) Permission(w http.ResponseWriter, r *http.Request) {
+	id, email := r.PathValue("id"), r.PathValue("email")
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := a.authorize(w, r, id, store.RoleOwner); !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		userID, err := a.DB.RevokePermission(r.Context(), id, email)
+		if err != nil {
+			writePermissionErr(w, err)
+			return
+		}
+		// Roles are checked when a doc is joined, open sessions must rejoin
+		a.Hub.AccessChanged(r.Context(), id, userID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req permissionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	role, ok := store.ParseGrantable(req.Role)
+	if !ok {
+		http.Error(w, "role must be editor, commenter or viewer", http.StatusBadRequest)
+		return
+	}
+	p, err := a.DB.UpdatePermission(r.Context(), id, email, role)
+	if err != nil {
+		writePermissionErr(w, err)
+		return
+	}
+	a.Hub.AccessChanged(r.Context(), id, p.UserID)
+	writeJSON(w, toPermissionResponse(p))
+}
+
+// writePermissionErr maps store errors of the permission endpoints to HTTP statuses
+func writePermissionErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrPermissionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, store.ErrPermissionExists), errors.Is(err, store.ErrOwnerRole):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
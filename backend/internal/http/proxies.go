@@ -0,0 +1,68 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies are the load balancers allowed to report a client's address in
+// X-Forwarded-For
+type Proxies []*net.IPNet
+
+// ParseProxies reads IPs and CIDRs, skipping malformed entries
+func ParseProxies(list []string) Proxies {
+	var out Proxies
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (p Proxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from. X-Forwarded-For is only
+// believed when the connection is from a trusted proxy, and then read from
+// the right up to the first hop that isn't one, since anything further left
+// is whatever the client chose to send.
+func (p Proxies) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !p.contains(ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
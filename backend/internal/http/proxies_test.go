@@ -0,0 +1,62 @@
+package httpx
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	p := ParseProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1", "fd00::/8", "not-an-ip", "10.0.0.0/99"})
+	if len(p) != 4 {
+		t.Fatalf("parsed %d entries, want 4: %v", len(p), p)
+	}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.2", false},
+		{"192.168.44.5", true},
+		{"::1", true},
+		{"fd12::1", true},
+		{"fe80::1", false},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		if got := p.contains(tt.addr); got != tt.want {
+			t.Errorf("contains(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	p := ParseProxies([]string{"10.0.0.0/8"})
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"untrusted peer's header ignored", "203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:5000", "198.51.100.9", "198.51.100.9"},
+		{"spoofed left hops ignored", "10.0.0.5:5000", "1.2.3.4, 198.51.100.9", "198.51.100.9"},
+		{"chained proxies", "10.0.0.5:5000", "198.51.100.9, 10.0.0.6", "198.51.100.9"},
+		{"blank hops skipped", "10.0.0.5:5000", "198.51.100.9, , ", "198.51.100.9"},
+		{"only proxies", "10.0.0.5:5000", "10.0.0.6", "10.0.0.6"},
+		{"trusted proxy without header", "10.0.0.5:5000", "", "10.0.0.5"},
+		{"remote without port", "203.0.113.7", "", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := p.clientIP(r); got != tt.want {
+				t.Fatalf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -15,10 +15,10 @@ import (
 // NewRouter wires up all HTTP routes, middleware, and handlers
 func NewRouter(cfg app.Config, logger *slog.Logger, hub *ws.Hub, db *store.Postgres) http.Handler {
 	mw := NewMiddleware(cfg)
-	api := &DocsAPI{DB: db}
+	j := auth.New(cfg.JWTSecret)
+	api := &DocsAPI{DB: db, Hub: hub, JWT: j, Proxies: ParseProxies(cfg.TrustedProxies)}
 
 	// Auth API
-	j := auth.New(cfg.JWTSecret)
 	authAPI := &AuthAPI{DB: db, JWT: j}
 
 	mux := http.NewServeMux()
@@ -34,8 +34,9 @@ func NewRouter(cfg app.Config, logger *slog.Logger, hub *ws.Hub, db *store.Postg
 	mux.Handle("/readyz",  healthHandler)
 	mux.Handle("/metrics", metrics.Handler())
 
-	// WebSocket endpoint
-	mux.Handle("/ws", http.HandlerFunc(hub.ServeWS))
+	// WebSocket endpoint (JWT via subprotocol or ticket)
+	mux.Handle("/ws",            mw.WSAuth(http.HandlerFunc(hub.ServeWS)))
+	mux.Handle("/api/ws-ticket", mw.Auth(http.HandlerFunc(authAPI.WSTicket)))
 
 	// Auth endpoints
 	mux.Handle("/api/auth/register", http.HandlerFunc(authAPI.Register))
@@ -49,6 +50,22 @@ func NewRouter(cfg app.Config, logger *slog.Logger, hub *ws.Hub, db *store.Postg
 		http.NotFound(w, r)
 	})))
 	mux.Handle("/api/docs/{id}", mw.Auth(http.HandlerFunc(api.Get)))
+	mux.Handle("/api/docs/{id}/presence", mw.Auth(http.HandlerFunc(api.Presence)))
+
+	// Version history (JWT-protected)
+	mux.Handle("/api/docs/{id}/versions",             mw.Auth(http.HandlerFunc(api.ListVersions)))
+	mux.Handle("/api/docs/{id}/versions/{v}",         mw.Auth(http.HandlerFunc(api.GetVersion)))
+	mux.Handle("/api/docs/{id}/versions/{v}/restore", mw.Auth(http.HandlerFunc(api.RestoreVersion)))
+
+	// Sharing (JWT-protected, changes are owner-only)
+	mux.Handle("/api/docs/{id}/permissions",         mw.Auth(http.HandlerFunc(api.Permissions)))
+	mux.Handle("/api/docs/{id}/permissions/{email}", mw.Auth(http.HandlerFunc(api.Permission)))
+	mux.Handle("/api/docs/{id}/shares",              mw.Auth(http.HandlerFunc(api.Shares)))
+	mux.Handle("/api/docs/{id}/shares/{linkId}",     mw.Auth(http.HandlerFunc(api.RevokeShare)))
+	mux.Handle("/api/docs/{id}/share-uses",          mw.Auth(http.HandlerFunc(api.ShareUses)))
+
+	// Share links (public; a signed-in visitor is recorded as such)
+	mux.Handle("/api/share/{token}", mw.OptionalAuth(http.HandlerFunc(api.ResolveShare)))
 
 	// Server wrapper with read timeout
 	s := &http.Server{
@@ -0,0 +1,191 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"realtime-docs/internal/store"
+	"realtime-docs/pkg/auth"
+)
+
+type createShareReq struct {
+	Role      string     `json:"role"`
+	Password  string     `json:"password,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type shareResponse struct {
+	ID          string     `json:"id"`
+	Token       string     `json:"token,omitempty"` // only returned on creation
+	Role        store.Role `json:"role"`
+	HasPassword bool       `json:"hasPassword"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+	Uses        int        `json:"uses"`
+}
+
+type shareUseResponse struct {
+	LinkID    string     `json:"linkId"`
+	Role      store.Role `json:"role"`
+	UserID    string     `json:"userId,omitempty"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"userAgent"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type resolvedShareResponse struct {
+	DocID     string     `json:"docId"`
+	Title     string     `json:"title"`
+	Role      store.Role `json:"role"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Ticket    string     `json:"ticket"` // pass as /ws?share=
+}
+
+func toShareResponse(l store.ShareLink) shareResponse {
+	return shareResponse{
+		ID: l.ID, Role: l.Role, HasPassword: l.HasPassword, ExpiresAt: l.ExpiresAt,
+		CreatedAt: l.CreatedAt, RevokedAt: l.RevokedAt, Uses: l.Uses,
+	}
+}
+
+// Shares lists (GET) or creates (POST) a doc's share links; owner only
+func (a *DocsAPI) Shares(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := a.authorize(w, r, id, store.RoleOwner); !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		ls, err := a.DB.ListShareLinks(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp := make([]shareResponse, 0, len(ls))
+		for _, l := range ls {
+			resp = append(resp, toShareResponse(l))
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	var req createShareReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	role, ok := store.ParseGrantable(req.Role)
+	if !ok {
+		http.Error(w, "role must be editor, commenter or viewer", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+	l, token, err := a.DB.CreateShareLink(r.Context(), id, auth.UserID(r.Context()), role, req.Password, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := toShareResponse(l)
+	resp.Token = token
+	writeJSON(w, resp)
+}
+
+// RevokeShare disables one of a doc's share links; owner only
+func (a *DocsAPI) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.NotFound(w, r)
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := a.authorize(w, r, id, store.RoleOwner); !ok {
+		return
+	}
+	if err := a.DB.RevokeShareLink(r.Context(), id, r.PathValue("linkId")); err != nil {
+		writeShareErr(w, err)
+		return
+	}
+	// Visitors already in the doc through the link are let go too
+	a.Hub.RevokeShare(r.Context(), r.PathValue("linkId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ShareUses shows who opened a doc through which link, newest first; owner only
+func (a *DocsAPI) ShareUses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := a.authorize(w, r, id, store.RoleOwner); !ok {
+		return
+	}
+	us, err := a.DB.ListShareUses(r.Context(), id, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := make([]shareUseResponse, 0, len(us))
+	for _, u := range us {
+		resp = append(resp, shareUseResponse{
+			LinkID: u.LinkID, Role: u.Role, UserID: u.UserID, IP: u.IP, UserAgent: u.UserAgent, CreatedAt: u.CreatedAt,
+		})
+	}
+	writeJSON(w, resp)
+}
+
+// ResolveShare opens a share link (password in X-Share-Password), records
+// the visit and returns a short-lived ticket for the WebSocket
+func (a *DocsAPI) ResolveShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	l, err := a.DB.ResolveShareLink(r.Context(), r.PathValue("token"), r.Header.Get("X-Share-Password"))
+	if err != nil {
+		writeShareErr(w, err)
+		return
+	}
+	d, err := a.DB.GetDoc(r.Context(), l.DocID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var uid string
+	if u := auth.UserID(r.Context()); u != "anon" {
+		uid = u
+	}
+	if err := a.DB.RecordShareUse(r.Context(), l.ID, uid, a.Proxies.clientIP(r), r.UserAgent()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ticket, err := a.JWT.SignShareTicket(l.ID, time.Minute)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resolvedShareResponse{DocID: d.ID, Title: d.Title, Role: l.Role, ExpiresAt: l.ExpiresAt, Ticket: ticket})
+}
+
+// writeShareErr maps store errors of the share endpoints to HTTP statuses
+func writeShareErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrShareNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, store.ErrSharePassword):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AcquireLease takes or renews the persistence lease of a doc for holder.
+// It fails (false) while another holder's lease hasn't expired.
+func (p *Postgres) AcquireLease(ctx context.Context, docID, holder string, ttl time.Duration) (bool, error) {
+	var got string
+	err := p.pool.QueryRow(ctx, `
+		INSERT INTO doc_leases (doc_id, holder, expires_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (doc_id) DO UPDATE
+		SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE doc_leases.holder = EXCLUDED.holder OR doc_leases.expires_at < NOW()
+		RETURNING holder
+	`, docID, holder, ttl.Milliseconds()).Scan(&got)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReleaseLease gives up holder's lease so another instance can take over at once
+func (p *Postgres) ReleaseLease(ctx context.Context, docID, holder string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM doc_leases WHERE doc_id = $1 AND holder = $2`, docID, holder)
+	return err
+}
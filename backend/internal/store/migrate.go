@@ -14,8 +14,17 @@ import (
 var migrations embed.FS
 
 
-// RunMigrations executes all embedded .sql files in order
+// RunMigrations executes the embedded .sql files not applied yet, in order.
+// Each runs in a transaction together with its schema_migrations record.
 func RunMigrations(ctx context.Context, p *Postgres, log *slog.Logger) error {
+	if _, err := p.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`); err != nil {
+		return err
+	}
 	entries, err := migrations.ReadDir("migrations")
 	if err != nil {
 		return err
@@ -28,10 +37,36 @@ func RunMigrations(ctx context.Context, p *Postgres, log *slog.Logger) error {
 		if err != nil {
 			return err
 		}
-		if _, err := p.pool.Exec(ctx, string(b)); err != nil {
+		applied, err := applyMigration(ctx, p, e.Name(), string(b))
+		if err != nil {
 			return fmt.Errorf("%s: %w", e.Name(), err)
 		}
-		log.Info("migration.applied", "file", e.Name())
+		if applied {
+			log.Info("migration.applied", "file", e.Name())
+		}
 	}
 	return nil
 }
+
+// applyMigration runs one migration unless it is recorded as applied. The
+// row lock on its record keeps instances starting together from both
+// running it.
+func applyMigration(ctx context.Context, p *Postgres, name, sql string) (bool, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO schema_migrations (name) VALUES ($1) ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS doc_updates (
+  seq BIGSERIAL PRIMARY KEY,
+  doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL,
+  bytes BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS doc_updates_doc_seq_idx ON doc_updates(doc_id, seq);
+CREATE INDEX IF NOT EXISTS doc_updates_created_at_idx ON doc_updates(created_at);
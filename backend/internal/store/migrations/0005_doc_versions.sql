@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS doc_versions (
+  doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  version BIGINT NOT NULL,
+  bytes BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (doc_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS doc_versions_created_at_idx ON doc_versions(doc_id, created_at DESC);
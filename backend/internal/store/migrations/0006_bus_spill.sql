@@ -0,0 +1,6 @@
+-- Oversized Postgres bus messages (NOTIFY payloads are capped at 8000 bytes)
+CREATE UNLOGGED TABLE IF NOT EXISTS bus_spill (
+  id BIGSERIAL PRIMARY KEY,
+  body BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS doc_permissions (
+  doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL CONSTRAINT doc_permissions_role_check CHECK (role IN ('owner', 'editor', 'viewer')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (doc_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS doc_permissions_user_idx ON doc_permissions(user_id);
+
+-- Existing docs are owned by their creator; docs that already have
+-- permissions were backfilled or created since
+INSERT INTO doc_permissions (doc_id, user_id, role)
+SELECT d.id, u.id, 'owner'
+FROM documents d
+JOIN users u ON u.id::text = d.created_by
+WHERE NOT EXISTS (SELECT 1 FROM doc_permissions p WHERE p.doc_id = d.id)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,39 @@
+-- Commenters can read and (once comments exist) comment, but not edit.
+-- The constraint is only swapped while it doesn't allow them yet.
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conname = 'doc_permissions_role_check'
+      AND pg_get_constraintdef(oid) LIKE '%commenter%'
+  ) THEN
+    ALTER TABLE doc_permissions DROP CONSTRAINT IF EXISTS doc_permissions_role_check;
+    ALTER TABLE doc_permissions ADD CONSTRAINT doc_permissions_role_check
+      CHECK (role IN ('owner', 'editor', 'commenter', 'viewer'));
+  END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS share_links (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE, -- sha256 of the token, the token itself is never stored
+  role TEXT NOT NULL CHECK (role IN ('editor', 'commenter', 'viewer')),
+  password_hash TEXT,
+  expires_at TIMESTAMPTZ,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS share_links_doc_idx ON share_links(doc_id);
+
+CREATE TABLE IF NOT EXISTS share_link_uses (
+  id BIGSERIAL PRIMARY KEY,
+  link_id UUID NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+  user_id TEXT, -- set when the visitor was signed in
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS share_link_uses_link_idx ON share_link_uses(link_id, created_at DESC);
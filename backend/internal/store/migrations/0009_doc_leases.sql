@@ -0,0 +1,6 @@
+-- Which instance persists a doc; other instances skip their saves
+CREATE TABLE IF NOT EXISTS doc_leases (
+  doc_id UUID PRIMARY KEY REFERENCES documents(id) ON DELETE CASCADE,
+  holder TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
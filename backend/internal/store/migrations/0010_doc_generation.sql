@@ -0,0 +1,2 @@
+-- Bumped by every version restore; saves of a state from before it are refused
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS generation BIGINT NOT NULL DEFAULT 0;
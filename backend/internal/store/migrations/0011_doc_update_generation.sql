@@ -0,0 +1,8 @@
+-- Generation of the doc state each update was made against; updates from
+-- before a restore are skipped when loading and dropped when compacting
+ALTER TABLE doc_updates ADD COLUMN IF NOT EXISTS generation BIGINT NOT NULL DEFAULT 0;
+
+-- Updates logged so far were made against the doc's current generation
+UPDATE doc_updates u SET generation = d.generation
+FROM documents d
+WHERE d.id = u.doc_id AND u.generation <> d.generation;
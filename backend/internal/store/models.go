@@ -7,7 +7,9 @@ type Doc struct {
 	Title     string
 	Bytes     []byte
 	Version   int64
+	Gen       int64 // bumped by each restore, see SaveDoc
 	CreatedBy string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Role      Role // caller's role, set by CreateDoc and ListDocs
 }
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Role is a user's access level on a doc
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleCommenter Role = "commenter"
+	RoleEditor    Role = "editor"
+	RoleOwner     Role = "owner"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleCommenter: 2, RoleEditor: 3, RoleOwner: 4}
+
+// AtLeast reports whether r grants everything min does; the zero Role grants nothing
+func (r Role) AtLeast(min Role) bool { return roleRank[r] > 0 && roleRank[r] >= roleRank[min] }
+
+// CanEdit reports whether r may change the doc's content
+func (r Role) CanEdit() bool { return r.AtLeast(RoleEditor) }
+
+// ParseGrantable parses a role that can be granted to another user or a
+// share link (not owner)
+func ParseGrantable(s string) (Role, bool) {
+	switch r := Role(s); r {
+	case RoleViewer, RoleCommenter, RoleEditor:
+		return r, true
+	}
+	return "", false
+}
+
+var (
+	// ErrUserNotFound is returned when granting to an unknown email
+	ErrUserNotFound = errors.New("user not found")
+	// ErrPermissionExists is returned when granting to a user who already has a role
+	ErrPermissionExists = errors.New("user already has a role on this doc")
+	// ErrPermissionNotFound is returned when changing or revoking a missing grant
+	ErrPermissionNotFound = errors.New("permission not found")
+	// ErrOwnerRole is returned when changing or revoking the owner's role
+	ErrOwnerRole = errors.New("the owner's role can't be changed")
+)
+
+type Permission struct {
+	DocID     string
+	UserID    string
+	Email     string
+	Role      Role
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DocRole returns userID's role on a doc, or "" if they have none.
+// Unknown docs give ErrDocNotFound.
+func (p *Postgres) DocRole(ctx context.Context, docID, userID string) (Role, error) {
+	var exists bool
+	var role *string
+	err := p.pool.QueryRow(ctx, `
+		SELECT true, dp.role
+		FROM documents d
+		LEFT JOIN doc_permissions dp ON dp.doc_id = d.id AND dp.user_id::text = $2
+		WHERE d.id::text = $1
+	`, docID, userID).Scan(&exists, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrDocNotFound
+	}
+	if err != nil || role == nil {
+		return "", err
+	}
+	return Role(*role), nil
+}
+
+// ListPermissions returns everyone with a role on a doc, owner first
+func (p *Postgres) ListPermissions(ctx context.Context, docID string) ([]Permission, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT dp.doc_id, dp.user_id, u.email, dp.role, dp.created_at, dp.updated_at
+		FROM doc_permissions dp
+		JOIN users u ON u.id = dp.user_id
+		WHERE dp.doc_id = $1
+		ORDER BY dp.role = 'owner' DESC, u.email
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Permission
+	for rows.Next() {
+		var pm Permission
+		if err := rows.Scan(&pm.DocID, &pm.UserID, &pm.Email, &pm.Role, &pm.CreatedAt, &pm.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, pm)
+	}
+	return out, rows.Err()
+}
+
+// GrantPermission gives the user with email a role on a doc
+func (p *Postgres) GrantPermission(ctx context.Context, docID, email string, role Role) (Permission, error) {
+	u, _, err := p.GetUserByEmail(ctx, email)
+	if err != nil {
+		return Permission{}, ErrUserNotFound
+	}
+	pm := Permission{DocID: docID, UserID: u.ID, Email: u.Email, Role: role}
+	err = p.pool.QueryRow(ctx, `
+		INSERT INTO doc_permissions (doc_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING created_at, updated_at
+	`, docID, u.ID, role).Scan(&pm.CreatedAt, &pm.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Permission{}, ErrPermissionExists
+	}
+	if err != nil {
+		return Permission{}, err
+	}
+	return pm, nil
+}
+
+// UpdatePermission changes the role of the user with email on a doc
+func (p *Postgres) UpdatePermission(ctx context.Context, docID, email string, role Role) (Permission, error) {
+	pm := Permission{DocID: docID, Email: normEmail(email), Role: role}
+	err := p.pool.QueryRow(ctx, `
+		UPDATE doc_permissions dp
+		SET role = $3, updated_at = NOW()
+		FROM users u
+		WHERE u.id = dp.user_id AND u.email = $2 AND dp.doc_id = $1 AND dp.role <> 'owner'
+		RETURNING dp.user_id, dp.created_at, dp.updated_at
+	`, docID, pm.Email, role).Scan(&pm.UserID, &pm.CreatedAt, &pm.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Permission{}, p.missingPermission(ctx, docID, pm.Email)
+	}
+	if err != nil {
+		return Permission{}, err
+	}
+	return pm, nil
+}
+
+// RevokePermission removes the role of the user with email on a doc and
+// returns the user's ID
+func (p *Postgres) RevokePermission(ctx context.Context, docID, email string) (string, error) {
+	email = normEmail(email)
+	var userID string
+	err := p.pool.QueryRow(ctx, `
+		DELETE FROM doc_permissions dp
+		USING users u
+		WHERE u.id = dp.user_id AND u.email = $2 AND dp.doc_id = $1 AND dp.role <> 'owner'
+		RETURNING dp.user_id
+	`, docID, email).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", p.missingPermission(ctx, docID, email)
+	}
+	return userID, err
+}
+
+// missingPermission tells apart a missing grant from an attempt on the owner
+func (p *Postgres) missingPermission(ctx context.Context, docID, email string) error {
+	var owner bool
+	err := p.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM doc_permissions dp JOIN users u ON u.id = dp.user_id
+			WHERE dp.doc_id = $1 AND u.email = $2 AND dp.role = 'owner'
+		)
+	`, docID, email).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner {
+		return ErrOwnerRole
+	}
+	return ErrPermissionNotFound
+}
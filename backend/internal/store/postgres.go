@@ -4,14 +4,23 @@ import (
 	"context"
 	"errors"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log/slog"
 	"realtime-docs/internal/app"
+	"realtime-docs/pkg/yjs"
 )
 
+// ErrDocNotFound is returned when a document ID doesn't exist
+var ErrDocNotFound = errors.New("doc not found")
+
+// ErrStaleGeneration refuses a save of a state the doc was restored away from
+var ErrStaleGeneration = errors.New("doc was restored since this state was loaded")
+
 type Postgres struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	pool      *pgxpool.Pool
+	log       *slog.Logger
+	retention Retention
 }
 
 // NewPostgres connects to postgres and returns a pool wrapper
@@ -20,14 +29,24 @@ func NewPostgres(ctx context.Context, cfg app.Config, log *slog.Logger) (*Postgr
 	if err != nil {
 		return nil, err
 	}
-	return &Postgres{pool: pool, log: log}, nil
+	return &Postgres{pool: pool, log: log, retention: Retention{
+		Every:  cfg.VersionEvery,
+		Keep:   cfg.VersionKeep,
+		MaxAge: cfg.VersionMaxAge,
+	}}, nil
 }
 
 func (p *Postgres) Close() { p.pool.Close() }
 
 // CreateDoc inserts a new document owned by userID
 func (p *Postgres) CreateDoc(ctx context.Context, title, userID string) (Doc, error) {
-	row := p.pool.QueryRow(ctx, `
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return Doc{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx, `
 		INSERT INTO documents (title, bytes, version, created_by)
 		VALUES ($1, ''::bytea, 0, $2)
 		RETURNING id, title, bytes, version, created_by, created_at, updated_at
@@ -37,17 +56,25 @@ func (p *Postgres) CreateDoc(ctx context.Context, title, userID string) (Doc, er
 	if err := row.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt); err != nil {
 		return Doc{}, err
 	}
-	return d, nil
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO doc_permissions (doc_id, user_id, role) VALUES ($1, $2, $3)
+	`, d.ID, userID, RoleOwner); err != nil {
+		return Doc{}, err
+	}
+	d.Role = RoleOwner
+	return d, tx.Commit(ctx)
 }
 
-// ListDocs returns docs sorted by last update
-func (p *Postgres) ListDocs(ctx context.Context, limit, offset int) ([]Doc, error) {
+// ListDocs returns the docs userID has a role on, sorted by last update
+func (p *Postgres) ListDocs(ctx context.Context, userID string, limit, offset int) ([]Doc, error) {
 	rows, err := p.pool.Query(ctx, `
-		SELECT id, title, bytes, version, created_by, created_at, updated_at
-		FROM documents
-		ORDER BY updated_at DESC
-		LIMIT $1 OFFSET $2
-	`, limit, offset)
+		SELECT d.id, d.title, d.bytes, d.version, d.created_by, d.created_at, d.updated_at, dp.role
+		FROM documents d
+		JOIN doc_permissions dp ON dp.doc_id = d.id
+		WHERE dp.user_id::text = $1
+		ORDER BY d.updated_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +83,7 @@ func (p *Postgres) ListDocs(ctx context.Context, limit, offset int) ([]Doc, erro
 	var out []Doc
 	for rows.Next() {
 		var d Doc
-		if err := rows.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		if err := rows.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt, &d.Role); err != nil {
 			return nil, err
 		}
 		out = append(out, d)
@@ -67,31 +94,62 @@ func (p *Postgres) ListDocs(ctx context.Context, limit, offset int) ([]Doc, erro
 // GetDoc fetches a document by ID
 func (p *Postgres) GetDoc(ctx context.Context, id string) (Doc, error) {
 	row := p.pool.QueryRow(ctx, `
-		SELECT id, title, bytes, version, created_by, created_at, updated_at
+		SELECT id, title, bytes, version, generation, created_by, created_at, updated_at
 		FROM documents
 		WHERE id = $1
 	`, id)
 
 	var d Doc
-	if err := row.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt); err != nil {
+	if err := row.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.Gen, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt); err != nil {
 		return Doc{}, err
 	}
 	return d, nil
 }
 
-// SaveDoc updates doc bytes, bumps version, and timestamp
-func (p *Postgres) SaveDoc(ctx context.Context, id string, blob []byte) error {
-	ct, err := p.pool.Exec(ctx, `
+// SaveDoc merges blob into the stored Yjs state, bumps version and timestamp.
+// Merging instead of overwriting means a writer holding an older state can
+// never drop newer edits. The previous state is kept per the version retention.
+// gen is the generation blob was loaded at; merging a state from before a
+// restore would bring back what was restored away, so it is refused with
+// ErrStaleGeneration.
+func (p *Postgres) SaveDoc(ctx context.Context, id string, blob []byte, gen int64) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var cur []byte
+	var version, curGen int64
+	if err := tx.QueryRow(ctx, `SELECT bytes, version, generation FROM documents WHERE id = $1 FOR UPDATE`, id).Scan(&cur, &version, &curGen); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrDocNotFound
+		}
+		return err
+	}
+	if gen != curGen {
+		return ErrStaleGeneration
+	}
+	merged, err := yjs.MergeUpdates(cur, blob)
+	if err != nil {
+		// stored bytes aren't a valid update (legacy snapshot), replace them
+		p.log.Warn("doc.merge", "id", id, "err", err)
+		merged = blob
+	}
+	if err := p.snapshotVersion(ctx, tx, id, version, cur); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
 		UPDATE documents
 		SET bytes = $2, version = version + 1, updated_at = NOW()
 		WHERE id = $1
-	`, id, blob)
-	if err != nil {
+	`, id, merged); err != nil {
 		return err
 	}
-	if ct.RowsAffected() == 0 {
-		return errors.New("doc not found")
+	if err := tx.Commit(ctx); err != nil {
+		return err
 	}
-	p.log.Info("doc.saved", "id", id, "bytes", len(blob))
+	p.log.Info("doc.saved", "id", id, "bytes", len(merged))
 	return nil
-}
\ No newline at end of file
+}
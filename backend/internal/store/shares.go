@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrShareNotFound is returned for unknown, revoked or expired share links
+	ErrShareNotFound = errors.New("share link not found")
+	// ErrSharePassword is returned when a link's password is missing or wrong
+	ErrSharePassword = errors.New("share link password required")
+)
+
+type ShareLink struct {
+	ID          string
+	DocID       string
+	Role        Role
+	HasPassword bool
+	ExpiresAt   *time.Time
+	CreatedBy   string
+	CreatedAt   time.Time
+	RevokedAt   *time.Time
+	Uses        int // times the link was opened, set by ListShareLinks
+}
+
+// Active reports whether the link can still be used
+func (l ShareLink) Active() bool {
+	return l.RevokedAt == nil && (l.ExpiresAt == nil || time.Now().Before(*l.ExpiresAt))
+}
+
+type ShareUse struct {
+	LinkID    string
+	Role      Role
+	UserID    string // empty for anonymous visitors
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// hashShareToken is how tokens are looked up; only the hash is stored
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateShareLink adds a link to a doc and returns it with its token, which
+// is only available here. An empty password or nil expiry means none.
+func (p *Postgres) CreateShareLink(ctx context.Context, docID, userID string, role Role, password string, expiresAt *time.Time) (ShareLink, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return ShareLink{}, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	var hash *string
+	if password != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return ShareLink{}, "", err
+		}
+		s := string(h)
+		hash = &s
+	}
+
+	l := ShareLink{DocID: docID, Role: role, HasPassword: hash != nil, ExpiresAt: expiresAt, CreatedBy: userID}
+	err := p.pool.QueryRow(ctx, `
+		INSERT INTO share_links (doc_id, token_hash, role, password_hash, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, docID, hashShareToken(token), role, hash, expiresAt, userID).Scan(&l.ID, &l.CreatedAt)
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	return l, token, nil
+}
+
+// ListShareLinks returns a doc's links, including revoked and expired ones, newest first
+func (p *Postgres) ListShareLinks(ctx context.Context, docID string) ([]ShareLink, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT l.id, l.doc_id, l.role, l.password_hash IS NOT NULL, l.expires_at, l.created_by, l.created_at, l.revoked_at,
+		       (SELECT COUNT(*) FROM share_link_uses u WHERE u.link_id = l.id)
+		FROM share_links l
+		WHERE l.doc_id = $1
+		ORDER BY l.created_at DESC
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ShareLink
+	for rows.Next() {
+		var l ShareLink
+		if err := rows.Scan(&l.ID, &l.DocID, &l.Role, &l.HasPassword, &l.ExpiresAt, &l.CreatedBy, &l.CreatedAt, &l.RevokedAt, &l.Uses); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// GetShareLink fetches a link by ID, whether or not it is still active
+func (p *Postgres) GetShareLink(ctx context.Context, id string) (ShareLink, error) {
+	var l ShareLink
+	err := p.pool.QueryRow(ctx, `
+		SELECT id, doc_id, role, password_hash IS NOT NULL, expires_at, created_by, created_at, revoked_at
+		FROM share_links
+		WHERE id::text = $1
+	`, id).Scan(&l.ID, &l.DocID, &l.Role, &l.HasPassword, &l.ExpiresAt, &l.CreatedBy, &l.CreatedAt, &l.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ShareLink{}, ErrShareNotFound
+	}
+	return l, err
+}
+
+// ResolveShareLink looks up an active link by token and checks its password
+func (p *Postgres) ResolveShareLink(ctx context.Context, token, password string) (ShareLink, error) {
+	var l ShareLink
+	var hash *string
+	err := p.pool.QueryRow(ctx, `
+		SELECT id, doc_id, role, password_hash, expires_at, created_by, created_at, revoked_at
+		FROM share_links
+		WHERE token_hash = $1
+	`, hashShareToken(token)).Scan(&l.ID, &l.DocID, &l.Role, &hash, &l.ExpiresAt, &l.CreatedBy, &l.CreatedAt, &l.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ShareLink{}, ErrShareNotFound
+	}
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if !l.Active() {
+		return ShareLink{}, ErrShareNotFound
+	}
+	if l.HasPassword = hash != nil; l.HasPassword {
+		if bcrypt.CompareHashAndPassword([]byte(*hash), []byte(password)) != nil {
+			return ShareLink{}, ErrSharePassword
+		}
+	}
+	return l, nil
+}
+
+// RevokeShareLink disables a doc's link; revoking twice is a no-op
+func (p *Postgres) RevokeShareLink(ctx context.Context, docID, id string) error {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE share_links SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE doc_id = $1 AND id::text = $2
+	`, docID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// RecordShareUse logs that a link was opened; userID is empty for anonymous visitors
+func (p *Postgres) RecordShareUse(ctx context.Context, linkID, userID, ip, userAgent string) error {
+	var uid *string
+	if userID != "" {
+		uid = &userID
+	}
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO share_link_uses (link_id, user_id, ip, user_agent)
+		VALUES ($1, $2, $3, $4)
+	`, linkID, uid, ip, userAgent)
+	return err
+}
+
+// ListShareUses returns the latest opens of a doc's links, newest first
+func (p *Postgres) ListShareUses(ctx context.Context, docID string, limit int) ([]ShareUse, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT u.link_id, l.role, COALESCE(u.user_id, ''), u.ip, u.user_agent, u.created_at
+		FROM share_link_uses u
+		JOIN share_links l ON l.id = u.link_id
+		WHERE l.doc_id = $1
+		ORDER BY u.created_at DESC
+		LIMIT $2
+	`, docID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ShareUse
+	for rows.Next() {
+		var u ShareUse
+		if err := rows.Scan(&u.LinkID, &u.Role, &u.UserID, &u.IP, &u.UserAgent, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"realtime-docs/pkg/yjs"
+)
+
+// AppendUpdate logs one incremental Yjs update and returns its sequence number.
+// gen is the generation the update was made against; updates from before a
+// restore are never replayed, see SaveDoc.
+func (p *Postgres) AppendUpdate(ctx context.Context, docID, userID string, update []byte, gen int64) (int64, error) {
+	var seq int64
+	err := p.pool.QueryRow(ctx, `
+		INSERT INTO doc_updates (doc_id, user_id, bytes, generation)
+		VALUES ($1, $2, $3, $4)
+		RETURNING seq
+	`, docID, userID, update, gen).Scan(&seq)
+	return seq, err
+}
+
+// LoadDoc fetches a document with its not yet compacted updates of the
+// current generation merged into Bytes
+func (p *Postgres) LoadDoc(ctx context.Context, id string) (Doc, error) {
+	d, err := p.GetDoc(ctx, id)
+	if err != nil {
+		return Doc{}, err
+	}
+	rows, err := p.pool.Query(ctx, `
+		SELECT bytes FROM doc_updates
+		WHERE doc_id = $1 AND generation = $2
+		ORDER BY seq
+	`, id, d.Gen)
+	if err != nil {
+		return Doc{}, err
+	}
+	defer rows.Close()
+
+	parts := [][]byte{d.Bytes}
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return Doc{}, err
+		}
+		parts = append(parts, b)
+	}
+	if err := rows.Err(); err != nil {
+		return Doc{}, err
+	}
+	if len(parts) > 1 {
+		if d.Bytes, err = yjs.MergeUpdates(parts...); err != nil {
+			return Doc{}, err
+		}
+	}
+	return d, nil
+}
+
+// CompactDoc folds a doc's updates logged before cutoff into its snapshot
+// and deletes them from the log, along with updates logged against an
+// older generation by instances that hadn't seen a restore yet. Returns how
+// many updates were folded.
+func (p *Postgres) CompactDoc(ctx context.Context, id string, cutoff time.Time) (int, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var snapshot []byte
+	var gen int64
+	if err := tx.QueryRow(ctx, `SELECT bytes, generation FROM documents WHERE id = $1 FOR UPDATE`, id).Scan(&snapshot, &gen); err != nil {
+		return 0, err
+	}
+	stale, err := tx.Exec(ctx, `DELETE FROM doc_updates WHERE doc_id = $1 AND generation <> $2`, id, gen)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT seq, bytes FROM doc_updates
+		WHERE doc_id = $1 AND created_at < $2
+		ORDER BY seq
+	`, id, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	parts := [][]byte{snapshot}
+	var maxSeq int64
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&maxSeq, &b); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		parts = append(parts, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(parts) == 1 {
+		if stale.RowsAffected() > 0 {
+			return 0, tx.Commit(ctx)
+		}
+		return 0, nil
+	}
+
+	merged, err := yjs.MergeUpdates(parts...)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE documents SET bytes = $2 WHERE id = $1`, id, merged); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM doc_updates WHERE doc_id = $1 AND seq <= $2`, id, maxSeq); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(parts) - 1, nil
+}
+
+// RunCompactor periodically compacts every doc with updates older than age.
+// Blocks until ctx is cancelled.
+func (p *Postgres) RunCompactor(ctx context.Context, every, age time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		cutoff := time.Now().Add(-age)
+		rows, err := p.pool.Query(ctx, `SELECT DISTINCT doc_id FROM doc_updates WHERE created_at < $1`, cutoff)
+		if err != nil {
+			p.log.Warn("doc.compact.scan", "err", err)
+			continue
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			n, err := p.CompactDoc(ctx, id, cutoff)
+			if err != nil {
+				p.log.Warn("doc.compact", "id", id, "err", err)
+				continue
+			}
+			p.log.Info("doc.compacted", "id", id, "updates", n)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type DocVersion struct {
+	DocID     string
+	Version   int64
+	Bytes     []byte // nil when listed
+	Size      int
+	CreatedAt time.Time
+}
+
+// Retention controls which snapshots are kept in doc_versions
+type Retention struct {
+	Every  time.Duration // minimum spacing between kept snapshots
+	Keep   int           // max snapshots per doc
+	MaxAge time.Duration // snapshots older than this are dropped (0 = kept regardless of age)
+}
+
+// ErrVersionNotFound is returned for unknown doc/version pairs
+var ErrVersionNotFound = errors.New("version not found")
+
+// ListVersions returns a doc's kept snapshots, newest first, without their bytes
+func (p *Postgres) ListVersions(ctx context.Context, docID string) ([]DocVersion, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT doc_id, version, octet_length(bytes), created_at
+		FROM doc_versions
+		WHERE doc_id = $1
+		ORDER BY version DESC
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DocVersion
+	for rows.Next() {
+		var v DocVersion
+		if err := rows.Scan(&v.DocID, &v.Version, &v.Size, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// GetVersion fetches one snapshot including its bytes
+func (p *Postgres) GetVersion(ctx context.Context, docID string, version int64) (DocVersion, error) {
+	row := p.pool.QueryRow(ctx, `
+		SELECT doc_id, version, bytes, created_at
+		FROM doc_versions
+		WHERE doc_id = $1 AND version = $2
+	`, docID, version)
+
+	var v DocVersion
+	if err := row.Scan(&v.DocID, &v.Version, &v.Bytes, &v.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return DocVersion{}, ErrVersionNotFound
+		}
+		return DocVersion{}, err
+	}
+	v.Size = len(v.Bytes)
+	return v, nil
+}
+
+// RestoreVersion makes a kept snapshot the doc's current state. The state
+// being replaced is snapshotted first so the restore itself can be undone,
+// and the update log is dropped since it describes the replaced state. The
+// generation is bumped so instances still holding the replaced state can't
+// save it back.
+func (p *Postgres) RestoreVersion(ctx context.Context, docID string, version int64) (Doc, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return Doc{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var cur []byte
+	var curVersion int64
+	if err := tx.QueryRow(ctx, `SELECT bytes, version FROM documents WHERE id = $1 FOR UPDATE`, docID).Scan(&cur, &curVersion); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Doc{}, ErrDocNotFound
+		}
+		return Doc{}, err
+	}
+	var blob []byte
+	if err := tx.QueryRow(ctx, `SELECT bytes FROM doc_versions WHERE doc_id = $1 AND version = $2`, docID, version).Scan(&blob); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Doc{}, ErrVersionNotFound
+		}
+		return Doc{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO doc_versions (doc_id, version, bytes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (doc_id, version) DO NOTHING
+	`, docID, curVersion, cur); err != nil {
+		return Doc{}, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM doc_updates WHERE doc_id = $1`, docID); err != nil {
+		return Doc{}, err
+	}
+
+	row := tx.QueryRow(ctx, `
+		UPDATE documents
+		SET bytes = $2, version = version + 1, generation = generation + 1, updated_at = NOW()
+		WHERE id = $1
+		RETURNING id, title, bytes, version, generation, created_by, created_at, updated_at
+	`, docID, blob)
+	var d Doc
+	if err := row.Scan(&d.ID, &d.Title, &d.Bytes, &d.Version, &d.Gen, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return Doc{}, err
+	}
+	if err := p.pruneVersions(ctx, tx, docID); err != nil {
+		return Doc{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Doc{}, err
+	}
+	p.log.Info("doc.restored", "id", docID, "from", version, "version", d.Version)
+	return d, nil
+}
+
+// snapshotVersion keeps the state about to be replaced if the last kept
+// snapshot is older than the retention spacing, then prunes
+func (p *Postgres) snapshotVersion(ctx context.Context, tx pgx.Tx, docID string, version int64, blob []byte) error {
+	if len(blob) == 0 {
+		return nil
+	}
+	var last *time.Time
+	if err := tx.QueryRow(ctx, `SELECT MAX(created_at) FROM doc_versions WHERE doc_id = $1`, docID).Scan(&last); err != nil {
+		return err
+	}
+	if last != nil && time.Since(*last) < p.retention.Every {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO doc_versions (doc_id, version, bytes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (doc_id, version) DO NOTHING
+	`, docID, version, blob); err != nil {
+		return err
+	}
+	return p.pruneVersions(ctx, tx, docID)
+}
+
+// pruneVersions applies the retention policy to one doc
+func (p *Postgres) pruneVersions(ctx context.Context, tx pgx.Tx, docID string) error {
+	if p.retention.MaxAge > 0 {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM doc_versions
+			WHERE doc_id = $1 AND created_at < $2
+		`, docID, time.Now().Add(-p.retention.MaxAge)); err != nil {
+			return err
+		}
+	}
+	if p.retention.Keep > 0 {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM doc_versions
+			WHERE doc_id = $1 AND version NOT IN (
+				SELECT version FROM doc_versions WHERE doc_id = $1 ORDER BY version DESC LIMIT $2
+			)
+		`, docID, p.retention.Keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"encoding/json"
+	"sort"
+
+	"realtime-docs/pkg/yjs"
+)
+
+// clientState is the last awareness state a Yjs client announced on a local member
+type clientState struct {
+	member *Member
+	clock  uint64
+	state  json.RawMessage
+}
+
+// remoteClient is a Yjs client of another instance whose awareness was
+// relayed to this room over the bus
+type remoteClient struct {
+	origin string
+	clock  uint64
+	state  json.RawMessage
+}
+
+// Presence is one user present in a doc
+type Presence struct {
+	UserID  string `json:"userId"`
+	Name    string `json:"name,omitempty"` // display name from the awareness state
+	Clients int    `json:"clients"`        // Yjs clients (tabs) of the user
+}
+
+// TrackAwareness records the states announced by m and returns the entries
+// it accepted. Entries older than what the room knows are ignored, as are
+// entries for a client ID another user's member announced, so nobody can
+// move or remove someone else's cursor; the same user reconnecting takes
+// its client over. Removals forget the client.
+func (r *Room) TrackAwareness(m *Member, entries []yjs.AwarenessEntry) []yjs.AwarenessEntry {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	accepted := entries[:0:0]
+	for _, e := range entries {
+		cur, ok := r.aware[e.ClientID]
+		if ok && (cur.member.conn.uid != m.conn.uid || e.Clock < cur.clock) {
+			continue
+		}
+		accepted = append(accepted, e)
+		if e.Removed() {
+			delete(r.aware, e.ClientID)
+			continue
+		}
+		r.aware[e.ClientID] = clientState{member: m, clock: e.Clock, state: e.State}
+	}
+	return accepted
+}
+
+// Editor is an entry of the editor list viewers get instead of awareness
+type Editor struct {
+	ClientID uint64 `json:"clientId"`
+	Name     string `json:"name,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// Editors lists the Yjs clients with awareness state on editor-tier
+// connections, here and on other instances
+func (r *Room) Editors() []Editor {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	out := []Editor{}
+	add := func(id uint64, state json.RawMessage) {
+		e := Editor{ClientID: id}
+		_ = json.Unmarshal(state, &e)
+		e.ClientID = id
+		out = append(out, e)
+	}
+	for id, s := range r.aware {
+		if !s.member.viewer {
+			add(id, s.state)
+		}
+	}
+	for id, rc := range r.remote {
+		add(id, rc.state)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ClientID < out[j].ClientID })
+	return out
+}
+
+// Forget drops the awareness of m's clients, returning the removal entries
+// its peers need to clear its cursors
+func (r *Room) Forget(m *Member) []yjs.AwarenessEntry {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	var out []yjs.AwarenessEntry
+	for id, s := range r.aware {
+		if s.member == m {
+			delete(r.aware, id)
+			out = append(out, removal(id, s.clock))
+		}
+	}
+	return out
+}
+
+// TrackRemote records which instance the clients of a relayed awareness
+// update live on
+func (r *Room) TrackRemote(origin string, entries []yjs.AwarenessEntry) {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	for _, e := range entries {
+		cur, ok := r.remote[e.ClientID]
+		if ok && e.Clock < cur.clock {
+			continue
+		}
+		if e.Removed() {
+			delete(r.remote, e.ClientID)
+			continue
+		}
+		r.remote[e.ClientID] = remoteClient{origin: origin, clock: e.Clock, state: e.State}
+	}
+}
+
+// ForgetOrigin drops the clients of an instance that went away, returning
+// the removal entries for the room's connections
+func (r *Room) ForgetOrigin(origin string) []yjs.AwarenessEntry {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	var out []yjs.AwarenessEntry
+	for id, rc := range r.remote {
+		if rc.origin == origin {
+			delete(r.remote, id)
+			out = append(out, removal(id, rc.clock))
+		}
+	}
+	return out
+}
+
+// removal is the entry that clears a client's state; y-protocols only
+// accepts it with a clock past the last one seen
+func removal(clientID, clock uint64) yjs.AwarenessEntry {
+	return yjs.AwarenessEntry{ClientID: clientID, Clock: clock + 1, State: json.RawMessage("null")}
+}
+
+// Presence lists the users with awareness state on this room's connections
+func (r *Room) Presence() []Presence {
+	r.awareMu.Lock()
+	defer r.awareMu.Unlock()
+	var out []Presence
+	for _, s := range r.aware {
+		var st struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(s.state, &st)
+		out = append(out, Presence{UserID: s.member.conn.uid, Name: st.Name, Clients: 1})
+	}
+	return mergePresence(out)
+}
+
+// mergePresence folds entries of the same user into one, sorted by user ID
+func mergePresence(lists ...[]Presence) []Presence {
+	byUser := map[string]*Presence{}
+	for _, l := range lists {
+		for _, p := range l {
+			if cur := byUser[p.UserID]; cur != nil {
+				cur.Clients += p.Clients
+				if cur.Name == "" {
+					cur.Name = p.Name
+				}
+				continue
+			}
+			p := p
+			byUser[p.UserID] = &p
+		}
+	}
+	out := make([]Presence, 0, len(byUser))
+	for _, p := range byUser {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out
+}
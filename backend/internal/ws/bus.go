@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+
+	"log/slog"
+	"realtime-docs/internal/app"
+)
+
+type BusMessage struct {
+	DocID   string `json:"docId"`
+	Payload []byte `json:"payload"`
+	Origin  string `json:"origin"`           // instance that published the message
+	ConnID  string `json:"connId,omitempty"` // sending connection, empty for server frames
+}
+
+// Bus fans frames out to the other instances serving the same docs
+type Bus interface {
+	// Publish sends a message to every subscriber of m.DocID
+	Publish(ctx context.Context, m BusMessage) error
+	// Subscribe invokes fn for each received message of watched docs until
+	// ctx is cancelled
+	Subscribe(ctx context.Context, fn func(BusMessage))
+	// Watch starts receiving a doc's messages
+	Watch(docID string)
+	// Unwatch stops receiving a doc's messages
+	Unwatch(docID string)
+	// Close releases the bus connection
+	Close()
+}
+
+// NewBus connects the bus backend selected by cfg.BusDriver
+func NewBus(ctx context.Context, cfg app.Config, log *slog.Logger) (Bus, error) {
+	switch cfg.BusDriver {
+	case "memory":
+		return NewMemoryBus(), nil
+	case "postgres":
+		return NewPostgresBus(ctx, cfg, log)
+	case "redis":
+		return NewRedisBus(ctx, cfg, log)
+	case "redis-streams":
+		return NewRedisStreamBus(ctx, cfg, log)
+	default:
+		return nil, fmt.Errorf("unknown bus driver %q", cfg.BusDriver)
+	}
+}
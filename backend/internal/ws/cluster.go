@@ -0,0 +1,189 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// clusterChannel is the bus topic every instance watches for messages that
+// aren't about a single room's doc
+const clusterChannel = "__cluster"
+
+// busTimeout bounds publishes made outside of a request
+const busTimeout = 5 * time.Second
+
+// clusterMsg is the JSON payload of a message on clusterChannel
+type clusterMsg struct {
+	Type     string     `json:"type"`               // "heartbeat", "bye", "presence_query", "presence", "revoke_share" or "access_changed"
+	ReqID    string     `json:"reqId,omitempty"`    // pairs replies with their query
+	DocID    string     `json:"docId,omitempty"`    // doc a presence query is about
+	To       string     `json:"to,omitempty"`       // instance a reply is for
+	Presence []Presence `json:"presence,omitempty"` // a reply's users
+	LinkID   string     `json:"linkId,omitempty"`   // share link whose visitors must leave
+	UserID   string     `json:"userId,omitempty"`   // user whose role on DocID changed
+}
+
+// publishCluster sends m to every other instance
+func (h *Hub) publishCluster(ctx context.Context, m clusterMsg) error {
+	b, _ := json.Marshal(m)
+	return h.bus.Publish(ctx, BusMessage{DocID: clusterChannel, Payload: b, Origin: h.instance})
+}
+
+// onCluster handles a message from another instance
+func (h *Hub) onCluster(ctx context.Context, msg BusMessage) {
+	var m clusterMsg
+	if err := json.Unmarshal(msg.Payload, &m); err != nil {
+		h.log.Warn("cluster.msg", "origin", msg.Origin, "err", err)
+		return
+	}
+	h.seen(msg.Origin)
+	switch m.Type {
+	case "bye":
+		h.peerGone(msg.Origin)
+
+	case "revoke_share":
+		h.closeShare(m.LinkID)
+
+	case "access_changed":
+		h.dropAccess(m.DocID, m.UserID)
+
+	case "presence_query":
+		// Answered even without a room, so the asker can stop once every peer replied
+		var p []Presence
+		h.mu.RLock()
+		rm := h.rooms[m.DocID]
+		h.mu.RUnlock()
+		if rm != nil {
+			p = rm.Presence()
+		}
+		_ = h.publishCluster(ctx, clusterMsg{Type: "presence", ReqID: m.ReqID, To: msg.Origin, Presence: p})
+
+	case "presence":
+		if m.To != h.instance {
+			return
+		}
+		h.queryMu.Lock()
+		ch := h.queries[m.ReqID]
+		h.queryMu.Unlock()
+		if ch != nil {
+			// Only a peer that came up after the query was sent finds the
+			// buffer full; the query doesn't wait for it anyway
+			select {
+			case ch <- m.Presence:
+			default:
+			}
+		}
+	}
+}
+
+// heartbeat announces this instance every HeartbeatEvery and declares peers
+// that stayed silent for PeerTimeout dead
+func (h *Hub) heartbeat(ctx context.Context) {
+	t := time.NewTicker(h.cfg.HeartbeatEvery)
+	defer t.Stop()
+	for {
+		_ = h.publishCluster(ctx, clusterMsg{Type: "heartbeat"})
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		h.peersMu.Lock()
+		var dead []string
+		for id, last := range h.peers {
+			if time.Since(last) > h.cfg.PeerTimeout {
+				dead = append(dead, id)
+			}
+		}
+		h.peersMu.Unlock()
+		for _, id := range dead {
+			h.log.Warn("cluster.peer.lost", "instance", id)
+			h.peerGone(id)
+		}
+	}
+}
+
+// seen records a sign of life from another instance
+func (h *Hub) seen(instance string) {
+	h.peersMu.Lock()
+	h.peers[instance] = time.Now()
+	h.peersMu.Unlock()
+}
+
+// livePeers counts the other instances currently alive
+func (h *Hub) livePeers() int {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+	return len(h.peers)
+}
+
+// peerGone forgets an instance that shut down or stopped heartbeating and
+// clears its clients' cursors on this instance's connections. Every surviving
+// instance does this for its own clients, so nothing goes on the bus.
+func (h *Hub) peerGone(instance string) {
+	h.peersMu.Lock()
+	delete(h.peers, instance)
+	h.peersMu.Unlock()
+
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, rm := range h.rooms {
+		rooms = append(rooms, rm)
+	}
+	h.mu.RUnlock()
+	for _, rm := range rooms {
+		if gone := rm.ForgetOrigin(instance); len(gone) > 0 {
+			rm.QueueAwareness(gone, false)
+		}
+	}
+}
+
+// Presence returns the users in a doc across the cluster: this instance's
+// rooms plus the answers of the live peers, waiting at most PresenceTimeout
+// for them
+func (h *Hub) Presence(ctx context.Context, docID string) ([]Presence, error) {
+	var lists [][]Presence
+	h.mu.RLock()
+	rm := h.rooms[docID]
+	h.mu.RUnlock()
+	if rm != nil {
+		lists = append(lists, rm.Presence())
+	}
+
+	peers := h.livePeers()
+	if peers == 0 {
+		return mergePresence(lists...), nil
+	}
+
+	// Room for a reply from every peer, so none is dropped however slowly
+	// they are collected
+	reqID := newConnID()
+	ch := make(chan []Presence, peers)
+	h.queryMu.Lock()
+	h.queries[reqID] = ch
+	h.queryMu.Unlock()
+	defer func() {
+		h.queryMu.Lock()
+		delete(h.queries, reqID)
+		h.queryMu.Unlock()
+	}()
+
+	if err := h.publishCluster(ctx, clusterMsg{Type: "presence_query", ReqID: reqID, DocID: docID}); err != nil {
+		return nil, err
+	}
+	t := time.NewTimer(h.cfg.PresenceTimeout)
+	defer t.Stop()
+	for replies := 0; replies < peers; {
+		select {
+		case l := <-ch:
+			lists = append(lists, l)
+			replies++
+		case <-t.C:
+			return mergePresence(lists...), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return mergePresence(lists...), nil
+}
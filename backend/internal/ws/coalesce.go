@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"realtime-docs/pkg/metrics"
+	"realtime-docs/pkg/yjs"
+)
+
+// awarenessBatch collects a room's awareness entries between flushes, keeping
+// only the newest entry per Yjs client
+type awarenessBatch struct {
+	mu     sync.Mutex
+	local  map[uint64]yjs.AwarenessEntry // from this instance's conns, also published
+	remote map[uint64]yjs.AwarenessEntry // relayed from other instances
+	frames int                           // frames folded into the batch
+	wake   chan struct{}
+}
+
+func newAwarenessBatch() awarenessBatch {
+	return awarenessBatch{
+		local:  map[uint64]yjs.AwarenessEntry{},
+		remote: map[uint64]yjs.AwarenessEntry{},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// QueueAwareness adds the entries of one awareness frame to the next combined
+// frame. Local entries also go on the bus when the batch is flushed.
+func (r *Room) QueueAwareness(entries []yjs.AwarenessEntry, local bool) {
+	b := &r.batch
+	b.mu.Lock()
+	into := b.remote
+	if local {
+		into = b.local
+	}
+	for _, e := range entries {
+		if cur, ok := into[e.ClientID]; !ok || e.Clock >= cur.Clock {
+			into[e.ClientID] = e
+		}
+	}
+	b.frames++
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunAwareness sends the queued awareness as one frame per window until the
+// room closes. The window starts at base and grows with the room, up to max,
+// since every frame is fanned out to every connection.
+func (r *Room) RunAwareness(base, max time.Duration, publish func([]byte)) {
+	for {
+		select {
+		case <-r.done:
+			r.flushAwareness(publish)
+			return
+		case <-r.batch.wake:
+		}
+		window := r.awarenessWindow(base, max)
+		metrics.AwarenessWindowSeconds.Observe(window.Seconds())
+		select {
+		case <-r.done:
+		case <-time.After(window):
+		}
+		r.flushAwareness(publish)
+	}
+}
+
+// awarenessWindow grows base by one step per 10 editor-tier connections,
+// the only ones awareness is fanned out to
+func (r *Room) awarenessWindow(base, max time.Duration) time.Duration {
+	n, _ := r.Count()
+	w := base * time.Duration(1+n/10)
+	if w > max {
+		w = max
+	}
+	return w
+}
+
+// flushAwareness broadcasts the batch as one frame. Senders get their own
+// states back too; clients ignore clocks they already have.
+func (r *Room) flushAwareness(publish func([]byte)) {
+	b := &r.batch
+	b.mu.Lock()
+	local, remote, frames := b.local, b.remote, b.frames
+	if frames == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.local, b.remote, b.frames = map[uint64]yjs.AwarenessEntry{}, map[uint64]yjs.AwarenessEntry{}, 0
+	b.mu.Unlock()
+
+	all := make([]yjs.AwarenessEntry, 0, len(local)+len(remote))
+	for id, e := range remote {
+		if l, ok := local[id]; !ok || e.Clock > l.Clock {
+			all = append(all, e)
+		}
+	}
+	var mine []yjs.AwarenessEntry
+	for id, e := range local {
+		if rem, ok := remote[id]; !ok || e.Clock >= rem.Clock {
+			all = append(all, e)
+		}
+		mine = append(mine, e)
+	}
+
+	if len(mine) > 0 {
+		publish(frame(msgAwareness, yjs.EncodeAwareness(mine)))
+	}
+	// Viewers get the editor list instead of every cursor
+	conns, viewers := r.Count()
+	r.broadcast.Add(1)
+	r.sendEditors(frame(msgAwareness, yjs.EncodeAwareness(all)), nil)
+	if viewers > 0 {
+		r.sendEditorList()
+	}
+
+	metrics.AwarenessFrames.WithLabelValues("received").Add(float64(frames))
+	metrics.AwarenessFrames.WithLabelValues("sent").Inc()
+	metrics.AwarenessSavedSends.Add(float64((frames - 1) * conns))
+}
+
+// sendEditorList feeds the editor list to the viewers when it changed
+func (r *Room) sendEditorList() {
+	list := r.Editors()
+	b, _ := json.Marshal(list)
+	if bytes.Equal(b, r.lastEditors) {
+		return
+	}
+	r.lastEditors = b
+	f := controlFrame(control{Type: "editors", Editors: list})
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.feed.append(f)
+	for m := range r.viewers {
+		m.conn.wake()
+	}
+}
@@ -2,75 +2,343 @@ package ws
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"nhooyr.io/websocket"
+	"realtime-docs/internal/app"
+	"realtime-docs/pkg/metrics"
 )
 
 type Conn struct {
+	id    string // random, tags this conn's frames on the bus
 	ws    *websocket.Conn
-	out   chan []byte
-	saveQ chan []byte
-	docID string
-	rm    *Room
+	out   chan []byte // encoded for the wire
+	uid   string      // authenticated user, "share:<linkID>" for share link visitors
+	opts  ConnOptions
+	proto int  // negotiated protocol version
+	mux   bool // frames carry a channel ID, see Member
+
+	limits *budget // inbound frame budget of this connection
+
+	membersMu sync.Mutex
+	members   map[uint64]*Member // the conn's docs by channel, 0 when not multiplexed
+	viewers   atomic.Int32       // members served from their room's feed
+
+	// A frame dropped on a full out queue leaves the client's doc behind, so
+	// the conn is marked stale and gets the full state once the queue drains
+	staleSince atomic.Int64  // unix nanos of the first dropped frame, 0 when in sync
+	kick       chan struct{} // wakes WriteLoop for new feed frames or a pending resync
+	closing    atomic.Bool   // set by the first CloseWith
 }
 
-// Accept upgrades HTTP to websocket (allow all origins)
-func Accept(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+// ConnOptions are per-connection settings from app.Config
+type ConnOptions struct {
+	SlowGrace    time.Duration  // how long a stale conn may lag before it is disconnected
+	PingEvery    time.Duration  // interval between pings
+	PongTimeout  time.Duration  // a ping not answered within this tears the conn down
+	WriteTimeout time.Duration  // deadline for each frame write
+	MaxFrame     int            // largest inbound frame in bytes
+	Limits       app.RateLimits // inbound budgets per connection
+	Queue        int            // outbound frames buffered before the conn is stale
+}
+
+// StatusResync closes a connection that fell too far behind; the client
+// should reconnect and sync from scratch
+const StatusResync websocket.StatusCode = 4001
+
+// Accept upgrades HTTP to websocket for the allowed origin host patterns,
+// selecting the newest protocol version the client offers. Browsers that
+// offer a "bearer.<jwt>" token must also offer one the server selects.
+func Accept(w http.ResponseWriter, r *http.Request, origins []string) (*websocket.Conn, error) {
 	return websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		Subprotocols:    subprotocols,
+		OriginPatterns:  origins,
 		CompressionMode: websocket.CompressionDisabled,
 	})
 }
 
-// NewConn wraps a WS connection for a specific doc + room
-func NewConn(ws *websocket.Conn, docID string, rm *Room) *Conn {
+// originHosts turns CORS origins ("https://app.example.com") into the host
+// patterns Accept matches against; "*" passes through
+func originHosts(allow []string) []string {
+	var out []string
+	for _, o := range allow {
+		if u, err := url.Parse(o); err == nil && u.Host != "" {
+			out = append(out, u.Host)
+		} else {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// NewConn wraps a WS connection of user uid speaking protocol version proto;
+// the hub adds a member per doc
+func NewConn(ws *websocket.Conn, uid string, proto int, mux bool, opts ConnOptions) *Conn {
+	// Read enforces MaxFrame itself so it can tell oversized frames apart
+	ws.SetReadLimit(-1)
 	return &Conn{
-		ws: ws, docID: docID, rm: rm,
-		out:   make(chan []byte, 256),
-		saveQ: make(chan []byte, 64),
+		id: newConnID(), ws: ws, uid: uid, opts: opts, mux: mux,
+		proto:   proto,
+		out:     make(chan []byte, opts.Queue),
+		kick:    make(chan struct{}, 1),
+		limits:  newBudget(opts.Limits, opts.MaxFrame),
+		members: map[uint64]*Member{},
 	}
 }
 
+// newConnID returns a random connection ID
+func newConnID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Proto returns the protocol version negotiated with the client
+func (c *Conn) Proto() int { return c.proto }
+
+// fromWire translates an inbound frame to the v1 layout
+func (c *Conn) fromWire(b []byte) ([]byte, error) {
+	if c.proto == protoV2 {
+		return fromV2(b)
+	}
+	return b, nil
+}
+
+// toWire translates a v1 frame to the client's protocol version
+func (c *Conn) toWire(b []byte) []byte {
+	if c.proto == protoV2 {
+		return toV2(b)
+	}
+	return b
+}
+
 // Read blocks until it receives a text/binary message
-// Returns false if connection is closed
+// Returns false if connection is closed, or closes it with
+// StatusMessageTooBig on a frame over MaxFrame
 func (c *Conn) Read(ctx context.Context) ([]byte, bool) {
 	for {
-		typ, data, err := c.ws.Read(ctx)
+		typ, r, err := c.ws.Reader(ctx)
 		if err != nil {
 			return nil, false
 		}
+		data, err := io.ReadAll(io.LimitReader(r, int64(c.opts.MaxFrame)+1))
+		if err != nil {
+			return nil, false
+		}
+		if len(data) > c.opts.MaxFrame {
+			metrics.WSLimitViolations.WithLabelValues("frame_size", "conn").Inc()
+			c.CloseWith(websocket.StatusMessageTooBig, "frame too large")
+			return nil, false
+		}
 		if typ == websocket.MessageText || typ == websocket.MessageBinary {
-			return []byte(data), true
+			return data, true
 		}
 	}
 }
 
-// WriteLoop sends outbound messages + periodic pings
-// Exits when ctx is cancelled
+// WriteLoop sends outbound messages, and the full state to a stale conn once
+// its queue has drained. Viewer members also follow their room's feed. It
+// pings the client in the background; a failed write or a missing pong tears
+// the connection down so the reader returns.
+// Exits when ctx is cancelled or the connection is dead.
 func (c *Conn) WriteLoop(ctx context.Context) {
-	p := 20 * time.Second
-	t := time.NewTicker(p)
-	defer t.Stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.pingLoop(ctx, cancel)
 
 	for {
+		if err := c.drainFeeds(ctx); err != nil {
+			c.teardown()
+			return
+		}
 		select {
 		case b := <-c.out:
-			_ = c.ws.Write(ctx, websocket.MessageBinary, b)
-		case <-t.C:
-			_ = c.ws.Ping(ctx)
+			if err := c.write(ctx, b); err != nil {
+				c.teardown()
+				return
+			}
+		case <-c.kick:
 		case <-ctx.Done():
 			return
 		}
+		if len(c.out) == 0 && c.staleSince.Load() != 0 {
+			if err := c.resync(ctx); err != nil {
+				c.teardown()
+				return
+			}
+		}
 	}
 }
 
-// Saves returns a read-only channel of queued save events
-func (c *Conn) Saves() <-chan []byte { return c.saveQ }
+// drainFeeds writes the feed frames viewer members haven't seen yet, or the
+// full state of a room whose frames they fell so far behind on were
+// already overwritten
+func (c *Conn) drainFeeds(ctx context.Context) error {
+	if c.viewers.Load() == 0 {
+		return nil
+	}
+	for _, m := range c.Members() {
+		if !m.viewer {
+			continue
+		}
+		frames, next, ok := m.rm.feed.since(m.seq)
+		m.seq = next
+		if !ok {
+			if err := c.resyncMember(ctx, m); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, b := range frames {
+			if err := c.write(ctx, c.encode(m.channel, b)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-// QueueSave adds to save queue without blocking if full
-func (c *Conn) QueueSave(b []byte) { select { case c.saveQ <- b: default: } }
+// pingLoop pings every PingEvery and tears the connection down when a pong
+// doesn't arrive within PongTimeout. Pongs are only read while the reader
+// runs, which ServeWS guarantees.
+func (c *Conn) pingLoop(ctx context.Context, stop context.CancelFunc) {
+	t := time.NewTicker(c.opts.PingEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			pctx, cancel := context.WithTimeout(ctx, c.opts.PongTimeout)
+			err := c.ws.Ping(pctx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				metrics.WSTimeouts.WithLabelValues("pong").Inc()
+				c.teardown()
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// write sends one encoded frame within WriteTimeout
+func (c *Conn) write(ctx context.Context, b []byte) error {
+	wctx, cancel := context.WithTimeout(ctx, c.opts.WriteTimeout)
+	defer cancel()
+	err := c.ws.Write(wctx, websocket.MessageBinary, b)
+	if err != nil && wctx.Err() == context.DeadlineExceeded {
+		metrics.WSTimeouts.WithLabelValues("write").Inc()
+	}
+	return err
+}
+
+// teardown drops a dead connection without a close handshake
+func (c *Conn) teardown() { _ = c.ws.CloseNow() }
+
+// resync replaces everything the conn missed with the full state of each of
+// its rooms. Stale is cleared first: frames queued from here on are covered
+// either by the state or by the queue.
+func (c *Conn) resync(ctx context.Context) error {
+	c.staleSince.Store(0)
+	for _, m := range c.Members() {
+		if err := c.resyncMember(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resyncMember writes the full state of m's room
+func (c *Conn) resyncMember(ctx context.Context, m *Member) error {
+	state, err := m.rm.State()
+	if err != nil {
+		c.CloseWith(StatusResync, "resync required")
+		return err
+	}
+	if err := c.write(ctx, c.encode(m.channel, frame(msgSyncRes, state))); err != nil {
+		return err
+	}
+	metrics.WSResyncs.WithLabelValues("full_state").Inc()
+	return nil
+}
+
+// Send queues a connection-level frame, on channel 0 when multiplexed
+func (c *Conn) Send(b []byte) { c.enqueue(c.encode(0, b), isControl(b)) }
+
+// wake has WriteLoop look for new feed frames
+func (c *Conn) wake() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue queues an encoded frame. A full queue drops the frame and marks
+// the conn stale; one that stays behind past SlowGrace is disconnected.
+// Control frames aren't covered by the full state a stale conn gets, so
+// they are queued even then, and the conn is disconnected if they don't fit.
+func (c *Conn) enqueue(b []byte, control bool) {
+	if control || c.staleSince.Load() == 0 {
+		select {
+		case c.out <- b:
+			return
+		default:
+		}
+	}
+	metrics.WSDroppedFrames.Inc()
+	if control {
+		c.closeSlow()
+		return
+	}
+
+	now := time.Now().UnixNano()
+	if c.staleSince.CompareAndSwap(0, now) {
+		c.wake()
+		return
+	}
+	if since := c.staleSince.Load(); since != 0 && time.Duration(now-since) > c.opts.SlowGrace {
+		c.closeSlow()
+	}
+}
+
+// closeSlow disconnects a conn that can't keep up, once; enqueue runs under
+// room locks so the close handshake happens in the background
+func (c *Conn) closeSlow() {
+	if c.closing.CompareAndSwap(false, true) {
+		go c.close(StatusResync, "too slow, resync required")
+	}
+}
+
+// CloseWith closes the WS connection with a specific status; only the first
+// call on a conn has an effect
+func (c *Conn) CloseWith(code websocket.StatusCode, reason string) {
+	if c.closing.CompareAndSwap(false, true) {
+		c.close(code, reason)
+	}
+}
+
+// close runs the close handshake
+func (c *Conn) close(code websocket.StatusCode, reason string) {
+	if code == StatusResync {
+		metrics.WSResyncs.WithLabelValues("disconnect").Inc()
+	}
+	_ = c.ws.Close(code, reason)
+}
+
+// GoAway tells the client to reconnect after the given delay, then closes the
+// connection with StatusGoingAway
+func (c *Conn) GoAway(ctx context.Context, after time.Duration) {
+	_ = c.write(ctx, c.encode(0, controlFrame(control{Type: "reconnect", AfterMs: after.Milliseconds()})))
+	_ = c.ws.Close(websocket.StatusGoingAway, "server shutting down")
+}
 
 // Close closes the WS connection normally
 func (c *Conn) Close() error { return c.ws.Close(websocket.StatusNormalClosure, "bye") }
\ No newline at end of file
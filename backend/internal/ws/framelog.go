@@ -0,0 +1,48 @@
+package ws
+
+import "sync"
+
+// frameLogSize is how many frames a room's frame log keeps for readers to catch up
+const frameLogSize = 512
+
+// frameLog is a room's shared log of outbound frames. Frames are stored once,
+// numbered in sequence, and every reader follows them at its own pace.
+type frameLog struct {
+	mu   sync.Mutex
+	ring [][]byte
+	head uint64 // sequence number of the next frame
+}
+
+func newFrameLog(size int) *frameLog {
+	return &frameLog{ring: make([][]byte, size)}
+}
+
+// append adds a frame
+func (l *frameLog) append(b []byte) {
+	l.mu.Lock()
+	l.ring[l.head%uint64(len(l.ring))] = b
+	l.head++
+	l.mu.Unlock()
+}
+
+// next returns the sequence number the next frame will get
+func (l *frameLog) next() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// since returns the frames from sequence seq on and the sequence to continue
+// from. ok is false when frames after seq were already overwritten.
+func (l *frameLog) since(seq uint64) (frames [][]byte, next uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := uint64(len(l.ring))
+	if seq > l.head || l.head-seq > size {
+		return nil, l.head, false
+	}
+	for i := seq; i < l.head; i++ {
+		frames = append(frames, l.ring[i%size])
+	}
+	return frames, l.head, true
+}
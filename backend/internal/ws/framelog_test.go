@@ -0,0 +1,48 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrameLogSince(t *testing.T) {
+	frames := func(ids ...byte) [][]byte {
+		out := make([][]byte, 0, len(ids))
+		for _, id := range ids {
+			out = append(out, []byte{id})
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		appended int // frames 0..appended-1, into a log of 4
+		seq      uint64
+		want     [][]byte
+		next     uint64
+		ok       bool
+	}{
+		{"empty log", 0, 0, nil, 0, true},
+		{"caught up", 3, 3, nil, 3, true},
+		{"from the start", 3, 0, frames(0, 1, 2), 3, true},
+		{"from the middle", 3, 1, frames(1, 2), 3, true},
+		{"full ring", 4, 0, frames(0, 1, 2, 3), 4, true},
+		{"wrapped, still kept", 6, 2, frames(2, 3, 4, 5), 6, true},
+		{"wrapped, overwritten", 6, 1, nil, 6, false},
+		{"ahead of the log", 3, 5, nil, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFrameLog(4)
+			for i := 0; i < tt.appended; i++ {
+				l.append([]byte{byte(i)})
+			}
+			got, next, ok := l.since(tt.seq)
+			if ok != tt.ok || next != tt.next || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("since(%d) = %v, %d, %v; want %v, %d, %v", tt.seq, got, next, ok, tt.want, tt.next, tt.ok)
+			}
+			if l.next() != uint64(tt.appended) {
+				t.Fatalf("next() = %d, want %d", l.next(), tt.appended)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"context"
+	"errors"
+
+	"realtime-docs/pkg/metrics"
+)
+
+// frameHandler handles one decoded client frame for the member it came on
+type frameHandler func(ctx context.Context, m *Member, f inFrame)
+
+// errDenied rejects frames the member's role doesn't allow
+var errDenied = errors.New("denied")
+
+// errUnknownChannel rejects multiplexed frames for a channel not subscribed
+var errUnknownChannel = errors.New("unknown_channel")
+
+// routes maps each client frame type to its handler
+func (h *Hub) routes() map[byte]frameHandler {
+	return map[byte]frameHandler{
+		msgUpdate:    h.persist,
+		msgSyncRes:   h.persist, // snapshots are merged like any update, never stored as-is
+		msgSyncReq:   h.answerSync,
+		msgAwareness: h.trackAwareness,
+	}
+}
+
+// dispatch decodes a client frame, already in the v1 layout, and hands it to
+// its handler
+func (h *Hub) dispatch(ctx context.Context, m *Member, b []byte) {
+	f, err := decodeFrame(b)
+	if err != nil {
+		h.reject(m.conn, m.docID, f, err)
+		return
+	}
+	metrics.WSFrames.WithLabelValues(frameName(f.typ)).Inc()
+	h.handlers[f.typ](ctx, m, f)
+}
+
+// reject counts a frame that was not accepted
+func (h *Hub) reject(c *Conn, docID string, f inFrame, err error) {
+	metrics.WSRejectedFrames.WithLabelValues(err.Error()).Inc()
+	h.log.Debug("ws.frame.rejected", "doc", docID, "user", c.uid, "type", frameName(f.typ), "reason", err)
+}
+
+// persist merges a doc update into the room, logs it and relays it
+func (h *Hub) persist(ctx context.Context, m *Member, f inFrame) {
+	if !m.role.CanEdit() {
+		// Viewers still receive updates and presence but can't change the doc
+		h.reject(m.conn, m.docID, f, errDenied)
+		return
+	}
+	gen, err := m.rm.Apply(f.payload)
+	if err != nil {
+		h.log.Warn("ws.update.invalid", "doc", m.docID, "err", err)
+		h.reject(m.conn, m.docID, f, errBadPayload)
+		return
+	}
+	// Log every update so a crash between snapshot saves loses nothing
+	if _, err := h.db.AppendUpdate(ctx, m.docID, m.conn.uid, f.payload, gen); err != nil {
+		h.log.Error("ws.update.log", "doc", m.docID, "err", err)
+	}
+	m.rm.MarkDirty()
+	h.relay(ctx, m, f)
+}
+
+// answerSync replies to a sync request from the room's doc, which is
+// authoritative; peers are no longer asked
+func (h *Hub) answerSync(_ context.Context, m *Member, f inFrame) {
+	diff, err := m.rm.SyncReply(f.payload)
+	if err != nil {
+		h.log.Warn("ws.sync", "doc", m.docID, "err", err)
+		return
+	}
+	if len(diff) > 0 {
+		m.Send(frame(msgSyncRes, diff))
+	}
+}
+
+// trackAwareness records who the member's Yjs clients are and queues their
+// presence for the room's next combined awareness frame. Viewers count
+// towards presence but their cursors aren't shown to anyone.
+func (h *Hub) trackAwareness(_ context.Context, m *Member, f inFrame) {
+	entries := m.rm.TrackAwareness(m, f.awareness)
+	if !m.viewer && len(entries) > 0 {
+		m.rm.QueueAwareness(entries, true)
+	}
+}
+
+// relay sends a frame to every other client of the doc, on this instance and
+// across the bus, never echoing it back to the sender
+func (h *Hub) relay(ctx context.Context, m *Member, f inFrame) {
+	_ = h.bus.Publish(ctx, BusMessage{DocID: m.docID, Payload: f.raw, Origin: h.instance, ConnID: m.conn.id})
+	m.rm.Broadcast(f.raw, m)
+}
@@ -2,123 +2,657 @@ package ws
 
 import (
 	"context"
+	"errors"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"log/slog"
+	"nhooyr.io/websocket"
+	"realtime-docs/internal/app"
 	"realtime-docs/internal/store"
+	"realtime-docs/pkg/auth"
+	"realtime-docs/pkg/metrics"
+	"realtime-docs/pkg/ratelimit"
+	"realtime-docs/pkg/yjs"
 )
 
 type Hub struct {
-	log *slog.Logger
-	bus *RedisBus
-	db  *store.Postgres
+	cfg      app.Config
+	log      *slog.Logger
+	bus      Bus
+	db       *store.Postgres
+	instance string   // origin stamped on published messages
+	origins  []string // allowed Origin host patterns
 
 	mu    sync.RWMutex
-	rooms map[string]*Room // active doc rooms by docID
+	rooms map[string]*Room // doc rooms with at least one connection, by docID
+
+	userLimits *ratelimit.Keyed[*budget] // inbound budgets shared by a user's connections
+	handlers   map[byte]frameHandler     // client frame handlers by type
+
+	draining   atomic.Bool    // set by Shutdown, new connections are refused
+	persisters sync.WaitGroup // running room persisters
+
+	peersMu sync.Mutex
+	peers   map[string]time.Time // other instances by last heartbeat
+
+	queryMu sync.Mutex
+	queries map[string]chan []Presence // presence queries awaiting replies, by request ID
+
+	resumeMu sync.Mutex
+	resumes  map[string]*resumeSession // subscriptions that can be resumed, by token
+
+	hookMu     sync.RWMutex
+	openHooks  []RoomHook
+	closeHooks []RoomHook
 }
 
-// NewHub sets up the hub with redis bus + DB + logger
-func NewHub(logger *slog.Logger, bus *RedisBus, db *store.Postgres) *Hub {
-	return &Hub{log: logger, bus: bus, db: db, rooms: map[string]*Room{}}
+// NewHub sets up the hub with a bus + DB + logger
+func NewHub(cfg app.Config, logger *slog.Logger, bus Bus, db *store.Postgres) *Hub {
+	h := &Hub{cfg: cfg, log: logger, bus: bus, db: db, instance: cfg.InstanceID, origins: originHosts(cfg.CORSAllow), rooms: map[string]*Room{}, queries: map[string]chan []Presence{}, peers: map[string]time.Time{}, resumes: map[string]*resumeSession{}}
+	h.userLimits = ratelimit.NewKeyed(func() *budget {
+		return newBudget(cfg.WSUserLimits, cfg.WSMaxFrame)
+	}, userLimitsIdle)
+	h.handlers = h.routes()
+	return h
 }
 
-// Run listens to redis bus and forwards updates to local rooms
+// userLimitsIdle is how long a user's budget outlives their last connection
+const userLimitsIdle = 10 * time.Minute
+
+// Run listens to the bus and forwards updates to local rooms
 func (h *Hub) Run(ctx context.Context) {
+	h.bus.Watch(clusterChannel)
+	go h.heartbeat(ctx)
 	go h.bus.Subscribe(ctx, func(msg BusMessage) {
+		// Local connections already got our own frames in ServeWS
+		if msg.Origin == h.instance {
+			return
+		}
+		if msg.DocID == clusterChannel {
+			h.onCluster(ctx, msg)
+			return
+		}
 		h.mu.RLock()
 		rm := h.rooms[msg.DocID]
 		h.mu.RUnlock()
 		if rm != nil {
-			rm.Broadcast(msg.Payload)
+			// Keep this instance's copy of the doc in step with the others
+			if isDocUpdate(msg.Payload) {
+				if _, err := rm.Apply(msg.Payload[1:]); err == nil {
+					rm.MarkDirty() // persisted here if we hold the lease
+				}
+			}
+			if len(msg.Payload) > 1 && msg.Payload[0] == msgAwareness {
+				// Remembered so the cursors can be cleared if that instance
+				// dies, and sent on with the room's next combined frame
+				if entries, err := yjs.DecodeAwareness(msg.Payload[1:]); err == nil {
+					rm.TrackRemote(msg.Origin, entries)
+					rm.QueueAwareness(entries, false)
+				}
+				return
+			}
+			if c, ok := parseControl(msg.Payload); ok && c.Type == "reset" {
+				if err := rm.Reset(h.loadDoc(ctx, msg.DocID)); err != nil {
+					h.log.Error("ws.reset", "doc", msg.DocID, "err", err)
+				}
+			}
+			rm.Broadcast(msg.Payload, nil)
 		}
 	})
 	<-ctx.Done()
 }
 
-// room returns the Room for a doc, creating it if needed
-func (h *Hub) room(docID string) *Room {
+// RoomHook is called when a room opens or closes
+type RoomHook func(rm *Room)
+
+// OnRoomOpen registers fn to run whenever a room is created
+func (h *Hub) OnRoomOpen(fn RoomHook) {
+	h.hookMu.Lock()
+	h.openHooks = append(h.openHooks, fn)
+	h.hookMu.Unlock()
+}
+
+// OnRoomClose registers fn to run whenever a room is evicted or drained
+func (h *Hub) OnRoomClose(fn RoomHook) {
+	h.hookMu.Lock()
+	h.closeHooks = append(h.closeHooks, fn)
+	h.hookMu.Unlock()
+}
+
+func (h *Hub) runHooks(hooks *[]RoomHook, rm *Room) {
+	h.hookMu.RLock()
+	fns := *hooks
+	h.hookMu.RUnlock()
+	for _, fn := range fns {
+		fn(rm)
+	}
+}
+
+// errRoomFull refuses a member over its tier's per-room limit
+var errRoomFull = errors.New("room full")
+
+// errDocUnavailable reports a doc that failed to load
+var errDocUnavailable = errors.New("doc unavailable")
+
+// errDraining refuses joins once Shutdown started
+var errDraining = errors.New("shutting down")
+
+// join adds m to its doc's room, creating the room and subscribing to the
+// doc on the bus if needed. Editors and viewers are capped separately, so a
+// crowd of readers can't lock out the people writing. Once the hub drains no
+// room is joined, so none is opened behind Shutdown's back.
+func (h *Hub) join(m *Member) (*Room, error) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	rm := h.rooms[docID]
-	if rm == nil {
-		rm = NewRoom()
-		h.rooms[docID] = rm
-		go rm.Run()
+	if h.draining.Load() {
+		h.mu.Unlock()
+		return nil, errDraining
+	}
+	rm := h.rooms[m.docID]
+	if rm != nil {
+		editors, viewers := rm.Count()
+		if (m.viewer && viewers >= h.cfg.RoomMaxViewers) || (!m.viewer && editors >= h.cfg.RoomMaxEditors) {
+			h.mu.Unlock()
+			return nil, errRoomFull
+		}
+	}
+	opened := rm == nil
+	if opened {
+		rm = NewRoom(m.docID, h.log)
+		h.rooms[m.docID] = rm
+		metrics.BusSubscriptions.Inc()
+		metrics.BusSubscribeOps.WithLabelValues("subscribe").Inc()
+		metrics.Rooms.Inc()
+		go rm.Run(h.cfg.RoomTick, h.cfg.RoomIdle, h.evict)
+		go rm.RunAwareness(h.cfg.AwarenessWindow, h.cfg.AwarenessMaxWindow, h.publishAwareness(m.docID))
+		p := &persister{
+			docID: m.docID, rm: rm, db: h.db, log: h.log, holder: h.instance,
+			flush: h.cfg.SaveFlush, maxDelay: h.cfg.SaveMaxDelay, leaseTTL: h.cfg.SaveLeaseTTL,
+		}
+		h.persisters.Add(1)
+		go p.run(&h.persisters)
+	}
+	rm.Join(m)
+	m.rm = rm
+	h.mu.Unlock()
+	metrics.WSConns.WithLabelValues(m.tier()).Inc()
+
+	// Watching may take a round trip to the bus, so it happens outside
+	// h.mu; joiners still wait for it so they don't load before it
+	if opened {
+		h.bus.Watch(m.docID)
+		close(rm.watched)
+		h.runHooks(&h.openHooks, rm)
+	}
+	<-rm.watched
+	return rm, nil
+}
+
+// leave removes m from its room and clears its cursors on every peer, which
+// would otherwise linger until each client's own awareness timeout. Empty
+// rooms stay around (and in sync over the bus) until Run finds them idle, so
+// quick reconnects skip a reload.
+func (h *Hub) leave(m *Member) {
+	m.rm.Leave(m)
+	h.endResume(m)
+	metrics.WSConns.WithLabelValues(m.tier()).Dec()
+	// Viewers' awareness was never fanned out
+	if gone := m.rm.Forget(m); len(gone) > 0 && !m.viewer {
+		m.rm.QueueAwareness(gone, true)
+	}
+}
+
+// subscribe joins c to docID's room on channel and sends the initial sync.
+// The member joins (and the doc is watched on the bus) before the doc loads
+// so no update slips in between. A client presenting the resume token of a
+// recent subscription and the last "seq" it saw is only sent the frames it
+// missed, if the room still has them.
+func (h *Hub) subscribe(ctx context.Context, c *Conn, channel uint64, docID string, role store.Role, token string, seq uint64) (*Member, error) {
+	m := &Member{conn: c, docID: docID, role: role, channel: channel, viewer: !role.CanEdit()}
+	if rm := h.takeResume(token, c.uid, docID); rm != nil {
+		m.resume = &resumePoint{rm: rm, seq: seq}
 	}
-	return rm
+	rm, err := h.join(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := rm.Load(h.loadDoc(ctx, docID)); err != nil {
+		h.log.Error("ws.load", "doc", docID, "err", err)
+		h.leave(m)
+		return nil, errDocUnavailable
+	}
+	from := m.seq // a viewer's moves on in WriteLoop once added
+	c.addMember(m)
+	h.issueResume(m)
+	if token != "" {
+		result := "replayed"
+		if m.resume == nil {
+			result = "full_sync"
+		}
+		metrics.WSResumes.WithLabelValues(result).Inc()
+	}
+
+	// Send the room's state right away so a lone editor opens the latest content,
+	// then ask an editor for anything we lack (e.g. edits made while offline);
+	// anything else would only be rejected. A resumed client got the frames it missed instead and is told so, it
+	// then sends its offline edits without the handshake.
+	if m.resume == nil {
+		if state, err := rm.State(); err == nil && len(state) > 0 {
+			m.Send(frame(msgSyncRes, state))
+		}
+		if m.role.CanEdit() {
+			if sv, err := rm.StateVector(); err == nil {
+				m.Send(frame(msgSyncReq, sv))
+			}
+		}
+	}
+	if m.viewer {
+		m.Send(controlFrame(control{Type: "editors", Editors: rm.Editors()}))
+	}
+	m.Send(controlFrame(control{Type: "session", Token: m.token, Seq: from, Resumed: m.resume != nil}))
+	return m, nil
+}
+
+// publishAwareness returns the function a room's awareness batches are sent
+// to other instances with
+func (h *Hub) publishAwareness(docID string) func([]byte) {
+	return func(b []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), busTimeout)
+		defer cancel()
+		_ = h.bus.Publish(ctx, BusMessage{DocID: docID, Payload: b, Origin: h.instance})
+	}
+}
+
+// evict drops a room that is still idle: final flush, bus unsubscribe and
+// close hooks. A later join reloads the doc from storage.
+func (h *Hub) evict(rm *Room) {
+	h.mu.Lock()
+	if h.rooms[rm.DocID()] != rm || !rm.Empty() {
+		h.mu.Unlock()
+		return // rejoined meanwhile
+	}
+	delete(h.rooms, rm.DocID())
+	h.mu.Unlock()
+
+	h.closeRoom(rm)
+	metrics.RoomEvictions.Inc()
+	h.log.Debug("room.evicted", "doc", rm.DocID())
+}
+
+// closeRoom stops a room that was removed from h.rooms
+func (h *Hub) closeRoom(rm *Room) {
+	rm.Close() // final flush, stops Run
+	<-rm.watched
+	h.bus.Unwatch(rm.DocID())
+	metrics.BusSubscriptions.Dec()
+	metrics.BusSubscribeOps.WithLabelValues("unsubscribe").Inc()
+	metrics.Rooms.Dec()
+	h.runHooks(&h.closeHooks, rm)
 }
 
-// ServeWS handles a new /ws connection for a docId
+// ServeWS handles a new /ws connection, for the doc in ?docId or, with
+// ?mux=1, for the docs the client subscribes to. The user was authenticated
+// by the router; access to a doc is checked before joining it, for a single
+// doc before upgrading. A single doc is resumed with ?resume=<token>&seq=<n>.
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	docID := r.URL.Query().Get("docId")
-	if docID == "" {
+	mux := r.URL.Query().Get("mux") == "1"
+	if docID == "" && !mux {
 		http.Error(w, "docId required", http.StatusBadRequest)
 		return
 	}
 
-	conn, err := Accept(w, r)
+	if h.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var role store.Role
+	if !mux {
+		var err error
+		role, err = h.docRole(ctx, docID)
+		if errors.Is(err, store.ErrDocNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.log.Error("ws.access", "doc", docID, "err", err)
+			http.Error(w, "doc unavailable", http.StatusInternalServerError)
+			return
+		}
+		if role == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	conn, err := Accept(w, r, h.origins)
 	if err != nil {
 		h.log.Error("ws.accept", "err", err)
 		return
 	}
+	proto := negotiatedVersion(r, conn.Subprotocol())
+	if proto == 0 {
+		metrics.WSConnections.WithLabelValues("unsupported").Inc()
+		_ = conn.Close(StatusUnsupportedProtocol, "unsupported protocol, offer rtdocs.v1 or rtdocs.v2")
+		return
+	}
+	label := conn.Subprotocol()
+	if label == "" {
+		label = "none"
+	}
+	metrics.WSConnections.WithLabelValues(label).Inc()
 
-	rm := h.room(docID)
-	c := NewConn(conn, docID, rm)
-	rm.Join(c)
+	// A multiplexed conn may mix tiers, it gets the editor settings
+	c := NewConn(conn, wsUser(ctx), proto, mux, h.connOptions(!mux && !role.CanEdit()))
+	if !mux {
+		seq, _ := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+		if _, err := h.subscribe(ctx, c, 0, docID, role, r.URL.Query().Get("resume"), seq); err != nil {
+			h.log.Warn("ws.join", "doc", docID, "role", role, "err", err)
+			code := websocket.StatusInternalError
+			switch {
+			case errors.Is(err, errRoomFull):
+				code = websocket.StatusTryAgainLater
+			case errors.Is(err, errDraining):
+				code = websocket.StatusGoingAway
+			}
+			_ = conn.Close(code, err.Error())
+			return
+		}
+	}
 
-	// Outbound writer
+	// Outbound writer; a dead connection ends the reader below, which leaves
+	// the rooms right away
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go c.WriteLoop(ctx)
+	defer h.expireShare(ctx, c)()
 
-	// Debounced save loop (batch full snapshots every 250ms)
-	go func() {
-		const debounceDur = 250 * time.Millisecond
-		timer := time.NewTimer(debounceDur)
-		if !timer.Stop() { <-timer.C }
-		var latest []byte
-
-		for {
-			select {
-			case b, ok := <-c.Saves():
-				if !ok {
-					return
-				}
-				latest = b
-				if !timer.Stop() { select { case <-timer.C: default: } }
-				timer.Reset(debounceDur)
-
-			case <-timer.C:
-				if latest != nil {
-					_ = h.db.SaveDoc(ctx, docID, latest)
-					latest = nil
-				}
-				timer.Reset(debounceDur)
-
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+	// The user's budget is held for the conn's lifetime so all their conns
+	// keep sharing it. Visitors of a share link are strangers to each other,
+	// each conn gets its own.
+	key := c.uid
+	if auth.ShareLinkID(ctx) != "" {
+		key = "conn:" + c.id
+	}
+	user := h.userLimits.Acquire(key)
+	defer h.userLimits.Release(key)
 
-	// Inbound reader broadcast every frame, queue-save only snapshots
+	// Inbound reader: each frame is charged by its decoded type, then goes to
+	// the handler for that type
 	for {
 		payload, ok := c.Read(ctx)
 		if !ok {
 			break
 		}
+		ch, b, err := c.unwrap(payload)
+		var typ byte
+		if err == nil && len(b) > 0 {
+			typ = b[0]
+		}
+		if !c.allow(typ, len(payload), user) {
+			h.log.Warn("ws.rate_limited", "doc", docID, "user", c.uid)
+			break
+		}
+		if err != nil {
+			h.reject(c, "", inFrame{}, errBadPayload)
+			continue
+		}
+		if mux && ch == 0 {
+			h.muxControl(ctx, c, b)
+			continue
+		}
+		m := c.member(ch)
+		if m == nil {
+			h.reject(c, "", inFrame{}, errUnknownChannel)
+			continue
+		}
+		h.dispatch(ctx, m, b)
+	}
 
-		// Cross-instance + local broadcast
-		_ = h.bus.Publish(ctx, BusMessage{DocID: docID, Payload: payload})
-		rm.Broadcast(payload)
+	for _, m := range c.Members() {
+		h.leave(m)
+	}
+	_ = c.Close()
+}
+
+// Shutdown drains the hub for a restart: new connections are refused, every
+// client is told to reconnect after a jittered delay (so a rolling deploy
+// doesn't make them all come back at once) and disconnected, and every room
+// flushes its pending state. It returns once saves finish or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.draining.Store(true) // under mu so no join in progress misses it
+	rooms := h.rooms
+	h.rooms = map[string]*Room{}
+	h.mu.Unlock()
+
+	// Peers clear our clients' cursors now rather than after PeerTimeout
+	_ = h.publishCluster(ctx, clusterMsg{Type: "bye"})
 
-		// Frame type 3 = snapshot; strip type byte before saving
-		if len(payload) > 1 && payload[0] == 3 {
-			c.QueueSave(payload[1:])
+	var wg sync.WaitGroup
+	seen := map[*Conn]bool{} // multiplexed conns are in several rooms
+	for _, rm := range rooms {
+		for _, c := range rm.Conns() {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			after := h.cfg.ReconnectAfter
+			if j := h.cfg.ReconnectJitter; j > 0 {
+				after += time.Duration(rand.Int63n(int64(j)))
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.GoAway(ctx, after)
+			}()
 		}
 	}
+	wg.Wait()
 
-	rm.Leave(c)
-	_ = c.Close()
+	// Only now that every client was told to leave, so updates sent
+	// meanwhile are part of each room's final flush
+	for _, rm := range rooms {
+		h.closeRoom(rm)
+	}
+
+	saved := make(chan struct{})
+	go func() {
+		h.persisters.Wait()
+		close(saved)
+	}()
+	select {
+	case <-saved:
+		h.log.Info("ws.drained", "rooms", len(rooms))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connOptions are the per-connection settings from the config. Viewers are
+// pinged less often and only queue direct frames, broadcasts reach them
+// through their room's feed; they are the bulk of a large audience.
+func (h *Hub) connOptions(viewer bool) ConnOptions {
+	ping, queue := h.cfg.WSPingEvery, 256
+	if viewer {
+		ping, queue = h.cfg.WSViewerPingEvery, 16
+	}
+	return ConnOptions{
+		Queue:        queue,
+		SlowGrace:    h.cfg.SlowConsumerGrace,
+		PingEvery:    ping,
+		PongTimeout:  h.cfg.WSPongTimeout,
+		WriteTimeout: h.cfg.WSWriteTimeout,
+		MaxFrame:     h.cfg.WSMaxFrame,
+		Limits:       h.cfg.WSConnLimits,
+	}
+}
+
+// wsUser is the user a conn acts as, "share:<linkID>" for share link visitors
+func wsUser(ctx context.Context) string {
+	if linkID := auth.ShareLinkID(ctx); linkID != "" {
+		return "share:" + linkID
+	}
+	return auth.UserID(ctx)
+}
+
+// docRole returns the caller's role on docID; visitors through a share link
+// act with the link's role
+func (h *Hub) docRole(ctx context.Context, docID string) (store.Role, error) {
+	if linkID := auth.ShareLinkID(ctx); linkID != "" {
+		return h.shareRole(ctx, linkID, docID)
+	}
+	return h.db.DocRole(ctx, docID, auth.UserID(ctx))
+}
+
+// shareRole returns the role a share link grants on docID, or "" if the link
+// is for another doc, revoked or expired
+func (h *Hub) shareRole(ctx context.Context, linkID, docID string) (store.Role, error) {
+	l, err := h.db.GetShareLink(ctx, linkID)
+	if errors.Is(err, store.ErrShareNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if l.DocID != docID || !l.Active() {
+		return "", nil
+	}
+	return l.Role, nil
+}
+
+// expireShare closes a share link visitor's conn when the link expires, since
+// the link is only checked as the conn opens. It returns a func that stops
+// the timer.
+func (h *Hub) expireShare(ctx context.Context, c *Conn) func() bool {
+	linkID := auth.ShareLinkID(ctx)
+	if linkID == "" {
+		return func() bool { return false }
+	}
+	l, err := h.db.GetShareLink(ctx, linkID)
+	if err != nil || l.ExpiresAt == nil {
+		return func() bool { return false }
+	}
+	t := time.AfterFunc(time.Until(*l.ExpiresAt), func() {
+		c.CloseWith(websocket.StatusPolicyViolation, "share link expired")
+	})
+	return t.Stop
+}
+
+// RevokeShare disconnects everyone who joined through a share link, on every
+// instance; the link's checks only run when a connection opens
+func (h *Hub) RevokeShare(ctx context.Context, linkID string) {
+	h.closeShare(linkID)
+	if err := h.publishCluster(ctx, clusterMsg{Type: "revoke_share", LinkID: linkID}); err != nil {
+		h.log.Warn("ws.revoke_share", "link", linkID, "err", err)
+	}
+}
+
+// closeShare closes this instance's conns of a share link's visitors
+func (h *Hub) closeShare(linkID string) {
+	uid := "share:" + linkID
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, rm := range h.rooms {
+		rooms = append(rooms, rm)
+	}
+	h.mu.RUnlock()
+	seen := map[*Conn]bool{}
+	for _, rm := range rooms {
+		for _, c := range rm.Conns() {
+			if c.uid == uid && !seen[c] {
+				seen[c] = true
+				go c.CloseWith(websocket.StatusPolicyViolation, "share link revoked")
+			}
+		}
+	}
+}
+
+// AccessChanged makes a user whose role on a doc was changed or revoked
+// rejoin it, on every instance, so open sessions pick up the new role
+func (h *Hub) AccessChanged(ctx context.Context, docID, userID string) {
+	h.dropAccess(docID, userID)
+	if err := h.publishCluster(ctx, clusterMsg{Type: "access_changed", DocID: docID, UserID: userID}); err != nil {
+		h.log.Warn("ws.access_changed", "doc", docID, "user", userID, "err", err)
+	}
+}
+
+// dropAccess removes this instance's members of a user from a doc. A
+// multiplexed conn is told the subscription ended and keeps its other docs,
+// any other conn is closed; the client rejoins with its current role, if any.
+func (h *Hub) dropAccess(docID, userID string) {
+	h.mu.RLock()
+	rm := h.rooms[docID]
+	h.mu.RUnlock()
+	if rm == nil {
+		return
+	}
+	for _, m := range rm.Members() {
+		c := m.conn
+		if c.uid != userID {
+			continue
+		}
+		if !c.mux {
+			go c.CloseWith(websocket.StatusPolicyViolation, "access changed")
+			continue
+		}
+		gone := c.removeMember(m.channel)
+		if gone == nil {
+			continue // unsubscribed meanwhile
+		}
+		h.leave(gone)
+		c.Send(controlFrame(control{Type: "unsubscribed", Channel: gone.channel, DocID: docID, Error: "access changed"}))
+	}
+}
+
+// Restore makes a kept version the doc's state and tells connected clients on
+// every instance to reload. They hold the replaced state and merging it back
+// would undo the restore, so they must drop it rather than sync.
+func (h *Hub) Restore(ctx context.Context, docID string, version int64) (store.Doc, error) {
+	var d store.Doc
+	restore := func() ([]byte, int64, error) {
+		var err error
+		d, err = h.db.RestoreVersion(ctx, docID, version)
+		return d.Bytes, d.Gen, err
+	}
+
+	h.mu.RLock()
+	rm := h.rooms[docID]
+	h.mu.RUnlock()
+	var err error
+	if rm != nil {
+		err = rm.Reset(restore)
+	} else {
+		_, _, err = restore()
+	}
+	if err != nil {
+		return store.Doc{}, err
+	}
+
+	msg := controlFrame(control{Type: "reset", Version: d.Version})
+	_ = h.bus.Publish(ctx, BusMessage{DocID: docID, Payload: msg, Origin: h.instance})
+	if rm != nil {
+		rm.Broadcast(msg, nil)
+	}
+	return d, nil
+}
+
+// loadDoc returns the function rooms load docID from storage with
+func (h *Hub) loadDoc(ctx context.Context, docID string) func() ([]byte, int64, error) {
+	return func() ([]byte, int64, error) {
+		d, err := h.db.LoadDoc(ctx, docID)
+		return d.Bytes, d.Gen, err
+	}
+}
+
+// isDocUpdate reports whether a frame carries a Yjs update (types 1 and 3)
+func isDocUpdate(payload []byte) bool {
+	return len(payload) > 1 && (payload[0] == msgUpdate || payload[0] == msgSyncRes)
 }
@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"nhooyr.io/websocket"
+	"realtime-docs/internal/app"
+	"realtime-docs/pkg/metrics"
+	"realtime-docs/pkg/ratelimit"
+)
+
+// StatusRateLimited closes a connection that sent more than its budget
+const StatusRateLimited websocket.StatusCode = 4029
+
+// budget holds the token buckets enforcing app.RateLimits. Awareness
+// (cursor) traffic gets its own so chatty presence can't starve edits.
+type budget struct {
+	update, awareness rateBuckets
+}
+
+type rateBuckets struct {
+	frames, bytes *ratelimit.Bucket
+}
+
+// newBudget builds full buckets for l. Bursts allow two seconds of traffic,
+// and at least one frame of maxFrame bytes so a large initial sync passes.
+func newBudget(l app.RateLimits, maxFrame int) *budget {
+	return &budget{
+		update:    newRateBuckets(l.UpdateFrames, l.UpdateBytes, maxFrame),
+		awareness: newRateBuckets(l.AwarenessFrames, l.AwarenessBytes, maxFrame),
+	}
+}
+
+func newRateBuckets(frames, bytes, maxFrame int) rateBuckets {
+	burst := float64(2 * bytes)
+	if burst < float64(maxFrame) {
+		burst = float64(maxFrame)
+	}
+	return rateBuckets{
+		frames: ratelimit.NewBucket(float64(frames), float64(2*frames)),
+		bytes:  ratelimit.NewBucket(float64(bytes), burst),
+	}
+}
+
+// buckets returns the buckets a frame of type typ (v1 numbering) is charged to
+func (b *budget) buckets(typ byte) rateBuckets {
+	if typ == msgAwareness {
+		return b.awareness
+	}
+	return b.update
+}
+
+// allow charges one inbound frame of n wire bytes to b, returning the
+// exhausted kind ("frames" or "bytes") when it is over budget. Nothing is
+// taken then.
+func (b *budget) allow(typ byte, n int) (string, bool) {
+	rb := b.buckets(typ)
+	if !rb.frames.Allow(1) {
+		return "frames", false
+	}
+	if !rb.bytes.Allow(float64(n)) {
+		rb.frames.Refund(1)
+		return "bytes", false
+	}
+	return "", true
+}
+
+// refund gives back what allow took for a frame
+func (b *budget) refund(typ byte, n int) {
+	rb := b.buckets(typ)
+	rb.frames.Refund(1)
+	rb.bytes.Refund(float64(n))
+}
+
+// allow charges a decoded frame of type typ and n wire bytes to the conn's
+// and the user's budget, or to neither. Over either, the violation is counted
+// and the conn closed with StatusRateLimited.
+func (c *Conn) allow(typ byte, n int, user *budget) bool {
+	scope := "conn"
+	kind, ok := c.limits.allow(typ, n)
+	if ok && user != nil {
+		scope = "user"
+		if kind, ok = user.allow(typ, n); !ok {
+			c.limits.refund(typ, n)
+		}
+	}
+	if ok {
+		return true
+	}
+	metrics.WSLimitViolations.WithLabelValues(kind, scope).Inc()
+	c.CloseWith(StatusRateLimited, "rate limit exceeded")
+	return false
+}
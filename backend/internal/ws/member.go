@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"realtime-docs/internal/store"
+)
+
+// Member is a Conn's subscription to one doc's Room. A plain connection has
+// a single member on channel 0; a multiplexed one (/ws?mux=1) has one per
+// channel the client subscribed, each frame prefixed with its varUint
+// channel ID and channel 0 carrying the subscribe/unsubscribe control frames.
+type Member struct {
+	conn    *Conn
+	rm      *Room
+	docID   string
+	role    store.Role // access level, fixed for the subscription's lifetime
+	channel uint64
+
+	// Viewers (roles that can't edit) read the room's shared feed instead of
+	// the conn's queue, and get no awareness
+	viewer bool
+	seq    uint64 // next feed frame to write, owned by the conn's WriteLoop after Join
+
+	token  string       // resume token handed to the client
+	resume *resumePoint // set to continue where a dropped subscription left off, nil after Join if it can't
+}
+
+// errBadChannel rejects multiplexed frames without a valid channel prefix
+var errBadChannel = errors.New("ws: malformed channel prefix")
+
+// Send queues a frame of m's room for the client
+func (m *Member) Send(b []byte) { m.conn.enqueue(m.conn.encode(m.channel, b), isControl(b)) }
+
+// tier labels the member as "editor" or "viewer"
+func (m *Member) tier() string {
+	if m.viewer {
+		return "viewer"
+	}
+	return "editor"
+}
+
+// encode translates a v1 frame for the wire and prefixes its channel when
+// the conn is multiplexed
+func (c *Conn) encode(channel uint64, b []byte) []byte {
+	b = c.toWire(b)
+	if !c.mux {
+		return b
+	}
+	out := binary.AppendUvarint(make([]byte, 0, len(b)+2), channel)
+	return append(out, b...)
+}
+
+// splitChannel separates the channel prefix of a multiplexed frame
+func splitChannel(b []byte) (uint64, []byte, error) {
+	ch, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errBadChannel
+	}
+	return ch, b[n:], nil
+}
+
+// unwrap splits off the channel of an inbound frame, 0 when the conn isn't
+// multiplexed, and translates the frame to the v1 layout
+func (c *Conn) unwrap(b []byte) (uint64, []byte, error) {
+	var ch uint64
+	if c.mux {
+		var err error
+		if ch, b, err = splitChannel(b); err != nil {
+			return 0, nil, err
+		}
+	}
+	b, err := c.fromWire(b)
+	return ch, b, err
+}
+
+// addMember registers m on its channel
+func (c *Conn) addMember(m *Member) {
+	c.membersMu.Lock()
+	c.members[m.channel] = m
+	c.membersMu.Unlock()
+	if m.viewer {
+		c.viewers.Add(1)
+		c.wake() // frames fed since Join
+	}
+}
+
+// removeMember unregisters the member on channel, nil if there is none
+func (c *Conn) removeMember(channel uint64) *Member {
+	c.membersMu.Lock()
+	m := c.members[channel]
+	delete(c.members, channel)
+	c.membersMu.Unlock()
+	if m != nil && m.viewer {
+		c.viewers.Add(-1)
+	}
+	return m
+}
+
+// member returns the member on channel, nil if there is none
+func (c *Conn) member(channel uint64) *Member {
+	c.membersMu.Lock()
+	defer c.membersMu.Unlock()
+	return c.members[channel]
+}
+
+// Members returns a snapshot of the conn's members
+func (c *Conn) Members() []*Member {
+	c.membersMu.Lock()
+	defer c.membersMu.Unlock()
+	out := make([]*Member, 0, len(c.members))
+	for _, m := range c.members {
+		out = append(out, m)
+	}
+	return out
+}
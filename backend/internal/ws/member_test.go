@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       []byte
+		channel uint64
+		rest    []byte
+		err     error
+	}{
+		{"control channel", []byte{0x00, msgControl, '{', '}'}, 0, []byte{msgControl, '{', '}'}, nil},
+		{"one byte channel", []byte{0x07, msgUpdate, 0x01}, 7, []byte{msgUpdate, 0x01}, nil},
+		{"two byte channel", []byte{0x80, 0x01, msgSyncReq}, 128, []byte{msgSyncReq}, nil},
+		{"prefix only", []byte{0x03}, 3, []byte{}, nil},
+		{"empty", nil, 0, nil, errBadChannel},
+		{"truncated varint", []byte{0x80}, 0, nil, errBadChannel},
+		{"overflowing varint", bytes.Repeat([]byte{0xff}, 11), 0, nil, errBadChannel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, rest, err := splitChannel(tt.b)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if ch != tt.channel || !bytes.Equal(rest, tt.rest) {
+				t.Fatalf("got channel %d, rest % x; want %d, % x", ch, rest, tt.channel, tt.rest)
+			}
+		})
+	}
+}
+
+func TestEncodeUnwrap(t *testing.T) {
+	update := []byte{msgUpdate, 0xaa}
+	tests := []struct {
+		name    string
+		proto   int
+		mux     bool
+		channel uint64
+		wire    []byte
+	}{
+		{"v1", protoV1, false, 0, []byte{msgUpdate, 0xaa}},
+		{"v1 muxed", protoV1, true, 5, []byte{0x05, msgUpdate, 0xaa}},
+		{"v1 muxed wide channel", protoV1, true, 300, []byte{0xac, 0x02, msgUpdate, 0xaa}},
+		{"v2", protoV2, false, 0, []byte{v2Sync, v2SyncUpdate, 1, 0xaa}},
+		{"v2 muxed", protoV2, true, 2, []byte{0x02, v2Sync, v2SyncUpdate, 1, 0xaa}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Conn{proto: tt.proto, mux: tt.mux}
+			wire := c.encode(tt.channel, update)
+			if !bytes.Equal(wire, tt.wire) {
+				t.Fatalf("encode = % x, want % x", wire, tt.wire)
+			}
+			ch, b, err := c.unwrap(wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch != tt.channel || !bytes.Equal(b, update) {
+				t.Fatalf("unwrap = %d, % x; want %d, % x", ch, b, tt.channel, update)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"context"
+	"sync"
+
+	"realtime-docs/pkg/metrics"
+)
+
+// MemoryBus is an in-process bus for single-node deployments and tests.
+// Every hub subscribed to the same MemoryBus sees every message.
+type MemoryBus struct {
+	mu   sync.RWMutex
+	subs map[chan BusMessage]struct{}
+}
+
+// NewMemoryBus creates an empty in-process bus
+func NewMemoryBus() *MemoryBus { return &MemoryBus{subs: map[chan BusMessage]struct{}{}} }
+
+// Publish hands the message to every subscriber. A subscriber that is
+// behind misses it rather than stalling the publisher, which may be that
+// subscriber's own handler.
+func (b *MemoryBus) Publish(_ context.Context, m BusMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- m:
+		default:
+			metrics.BusDropped.Inc()
+		}
+	}
+	return nil
+}
+
+// Subscribe delivers messages to fn until ctx is cancelled
+func (b *MemoryBus) Subscribe(ctx context.Context, fn func(BusMessage)) {
+	ch := make(chan BusMessage, 1024)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-ch:
+			fn(m)
+		}
+	}
+}
+
+// Watch is a no-op, every in-process message is cheap to deliver
+func (b *MemoryBus) Watch(docID string) {}
+
+// Unwatch is a no-op, see Watch
+func (b *MemoryBus) Unwatch(docID string) {}
+
+// Close is a no-op, there is no connection to release
+func (b *MemoryBus) Close() {}
@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"context"
+	"errors"
+
+	"realtime-docs/internal/store"
+)
+
+// muxControl handles a control frame on channel 0 of a multiplexed conn:
+// "subscribe" joins a doc on a client-chosen channel, "unsubscribe" leaves
+// it. Every request is answered, with an error if it was refused.
+func (h *Hub) muxControl(ctx context.Context, c *Conn, b []byte) {
+	req, ok := parseControl(b)
+	if !ok {
+		h.reject(c, "", inFrame{}, errBadPayload)
+		return
+	}
+	reply := control{Channel: req.Channel, DocID: req.DocID}
+	switch req.Type {
+	case "subscribe":
+		reply.Type = "subscribed"
+		m, err := h.muxSubscribe(ctx, c, req)
+		if err != nil {
+			reply.Type, reply.Error = "error", err.Error()
+			break
+		}
+		reply.Role = m.role
+		h.log.Debug("ws.subscribe", "doc", req.DocID, "user", c.uid, "channel", req.Channel)
+	case "unsubscribe":
+		reply.Type = "unsubscribed"
+		m := c.removeMember(req.Channel)
+		if m == nil {
+			reply.Type, reply.Error = "error", errUnknownChannel.Error()
+			break
+		}
+		reply.DocID = m.docID
+		h.leave(m)
+	default:
+		h.reject(c, "", inFrame{typ: msgControl}, errUnknownFrame)
+		return
+	}
+	c.Send(controlFrame(reply))
+}
+
+// muxSubscribe checks the caller's access to the requested doc and subscribes
+// c to it on the requested channel. Docs the caller has no role on are
+// reported as missing so IDs don't leak.
+func (h *Hub) muxSubscribe(ctx context.Context, c *Conn, req control) (*Member, error) {
+	channel, docID := req.Channel, req.DocID
+	switch {
+	case channel == 0 || docID == "":
+		return nil, errors.New("channel and docId required")
+	case c.member(channel) != nil:
+		return nil, errors.New("channel in use")
+	case len(c.Members()) >= h.cfg.WSMaxSubscriptions:
+		return nil, errors.New("too many subscriptions")
+	}
+	role, err := h.docRole(ctx, docID)
+	if err != nil && !errors.Is(err, store.ErrDocNotFound) {
+		h.log.Error("ws.access", "doc", docID, "err", err)
+		return nil, errDocUnavailable
+	}
+	if role == "" {
+		return nil, store.ErrDocNotFound
+	}
+	return h.subscribe(ctx, c, channel, docID, role, req.Token, req.Seq)
+}
@@ -0,0 +1,165 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"log/slog"
+	"realtime-docs/internal/store"
+	"realtime-docs/pkg/metrics"
+)
+
+// persister is a room's single writer to storage. It saves flush after the
+// last change, but never lets a busy doc stay unsaved longer than maxDelay.
+// Only the instance holding the doc's lease saves; every other instance
+// sends its updates to the holder over the bus (and into the update log).
+type persister struct {
+	docID    string
+	rm       *Room
+	db       *store.Postgres
+	log      *slog.Logger
+	holder   string // lease holder ID, the instance ID
+	flush    time.Duration
+	maxDelay time.Duration
+	leaseTTL time.Duration
+
+	leaseUntil time.Time // when our lease runs out, zero if not held
+}
+
+// storeTimeout bounds each storage call of the persister
+const storeTimeout = 10 * time.Second
+
+// run saves the room's doc as it changes until the room closes, then
+// flushes once more and releases the lease. wg is marked done on exit.
+func (p *persister) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	timer := time.NewTimer(p.flush)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	var dirtySince time.Time
+
+	for {
+		select {
+		case <-p.rm.Dirty():
+			now := time.Now()
+			if dirtySince.IsZero() {
+				dirtySince = now
+			}
+			// Debounce, but never past dirtySince+maxDelay
+			wait := p.flush
+			if left := dirtySince.Add(p.maxDelay).Sub(now); left < wait {
+				wait = max(left, 0)
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+		case <-timer.C:
+			p.save()
+			dirtySince = time.Time{}
+
+		case <-p.rm.Done():
+			timer.Stop()
+			// A change may have been marked just before the close, with
+			// both ready select picks either
+			select {
+			case <-p.rm.Dirty():
+				dirtySince = time.Now()
+			default:
+			}
+			if !dirtySince.IsZero() {
+				p.save()
+			}
+			p.release()
+			return
+		}
+	}
+}
+
+// save writes the room's state if this instance holds (or can take) the lease
+func (p *persister) save() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if !p.lease(ctx) {
+		metrics.DocSaves.WithLabelValues("skipped").Inc()
+		return
+	}
+	state, gen, err := p.rm.Snapshot()
+	if err != nil {
+		p.log.Error("doc.save.state", "doc", p.docID, "err", err)
+		metrics.DocSaves.WithLabelValues("error").Inc()
+		return
+	}
+
+	start := time.Now()
+	err = p.db.SaveDoc(ctx, p.docID, state, gen)
+	metrics.DocSaveSeconds.Observe(time.Since(start).Seconds())
+	if errors.Is(err, store.ErrStaleGeneration) {
+		p.reload(ctx)
+		return
+	}
+	if err != nil {
+		p.log.Error("doc.save", "doc", p.docID, "err", err)
+		metrics.DocSaves.WithLabelValues("error").Inc()
+		return
+	}
+	metrics.DocSaves.WithLabelValues("ok").Inc()
+	metrics.DocSaveBytes.Add(float64(len(state)))
+}
+
+// reload catches up with a restore this instance missed the bus reset of:
+// the room takes the restored state and its clients are told to drop theirs
+func (p *persister) reload(ctx context.Context) {
+	metrics.DocSaves.WithLabelValues("stale").Inc()
+	var d store.Doc
+	err := p.rm.Reset(func() ([]byte, int64, error) {
+		var err error
+		d, err = p.db.LoadDoc(ctx, p.docID)
+		return d.Bytes, d.Gen, err
+	})
+	if err != nil {
+		p.log.Error("doc.reload", "doc", p.docID, "err", err)
+		return
+	}
+	p.log.Warn("doc.save.stale", "doc", p.docID, "version", d.Version)
+	p.rm.Broadcast(controlFrame(control{Type: "reset", Version: d.Version}), nil)
+}
+
+// lease reports whether we may save, renewing the lease once half its TTL is used
+func (p *persister) lease(ctx context.Context) bool {
+	if time.Until(p.leaseUntil) > p.leaseTTL/2 {
+		return true
+	}
+	ok, err := p.db.AcquireLease(ctx, p.docID, p.holder, p.leaseTTL)
+	if err != nil {
+		p.log.Warn("doc.lease", "doc", p.docID, "err", err)
+		return false
+	}
+	if !ok {
+		p.leaseUntil = time.Time{}
+		return false
+	}
+	p.leaseUntil = time.Now().Add(p.leaseTTL)
+	return true
+}
+
+// release hands the lease back so another instance can persist right away
+func (p *persister) release() {
+	if p.leaseUntil.IsZero() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := p.db.ReleaseLease(ctx, p.docID, p.holder); err != nil {
+		p.log.Warn("doc.lease.release", "doc", p.docID, "err", err)
+	}
+	p.leaseUntil = time.Time{}
+}
@@ -0,0 +1,216 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"realtime-docs/internal/app"
+)
+
+// PostgresBus fans frames out with LISTEN/NOTIFY, for small deployments that
+// only run Postgres. Each doc has its own notification channel. NOTIFY
+// payloads are capped at 8000 bytes, so larger messages are parked in the
+// bus_spill table and only their ID is notified.
+type PostgresBus struct {
+	pool *pgxpool.Pool
+	url  string
+	log  *slog.Logger
+
+	mu   sync.Mutex
+	docs map[string]struct{} // watched docs
+	wake chan struct{}       // signals the listener that the watch set changed
+}
+
+const (
+	pgMaxNotify   = 7900 // stay below Postgres' 8000 byte NOTIFY limit
+	pgSpillPrefix = "spill:"
+	pgSpillTTL    = time.Minute
+)
+
+// NewPostgresBus connects to postgres and verifies connectivity
+func NewPostgresBus(ctx context.Context, cfg app.Config, log *slog.Logger) (*PostgresBus, error) {
+	pool, err := pgxpool.New(ctx, cfg.PGURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &PostgresBus{
+		pool: pool, url: cfg.PGURL, log: log,
+		docs: map[string]struct{}{},
+		wake: make(chan struct{}, 1),
+	}, nil
+}
+
+// Publish notifies the doc's listeners, spilling oversized messages to a table
+func (b *PostgresBus) Publish(ctx context.Context, m BusMessage) error {
+	raw, _ := json.Marshal(m)
+	payload := string(raw)
+	if len(raw) > pgMaxNotify {
+		var id int64
+		if err := b.pool.QueryRow(ctx, `INSERT INTO bus_spill (body) VALUES ($1) RETURNING id`, raw).Scan(&id); err != nil {
+			return err
+		}
+		payload = pgSpillPrefix + strconv.FormatInt(id, 10)
+	}
+	_, err := b.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, channel(m.DocID), payload)
+	return err
+}
+
+// Watch starts listening on the doc's channel
+func (b *PostgresBus) Watch(docID string) {
+	b.mu.Lock()
+	b.docs[docID] = struct{}{}
+	b.mu.Unlock()
+	b.poke()
+}
+
+// Unwatch stops listening on the doc's channel
+func (b *PostgresBus) Unwatch(docID string) {
+	b.mu.Lock()
+	delete(b.docs, docID)
+	b.mu.Unlock()
+	b.poke()
+}
+
+func (b *PostgresBus) poke() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Subscribe listens on a dedicated connection and invokes fn for each
+// message, reconnecting after errors until ctx is cancelled
+func (b *PostgresBus) Subscribe(ctx context.Context, fn func(BusMessage)) {
+	go b.expireSpill(ctx)
+	for ctx.Err() == nil {
+		if err := b.listen(ctx, fn); err != nil && ctx.Err() == nil {
+			b.log.Warn("bus.pg.listen", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (b *PostgresBus) listen(ctx context.Context, fn func(BusMessage)) error {
+	conn, err := pgx.Connect(ctx, b.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	listening := map[string]struct{}{}
+	for {
+		if err := b.syncListens(ctx, conn, listening); err != nil {
+			return err
+		}
+
+		// Wait for a notification, or until the watch set changes
+		waitCtx, cancel := context.WithCancel(ctx)
+		woke := make(chan struct{})
+		go func() {
+			select {
+			case <-b.wake:
+				close(woke)
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		if err != nil {
+			select {
+			case <-woke:
+				continue // timeouts leave the connection usable
+			default:
+				return err
+			}
+		}
+
+		bm, err := b.decode(ctx, n.Payload)
+		if err != nil {
+			b.log.Warn("bus.pg.decode", "err", err)
+			continue
+		}
+		if bm.DocID != "" {
+			fn(bm)
+		}
+	}
+}
+
+// syncListens issues LISTEN/UNLISTEN so conn follows the watched docs
+func (b *PostgresBus) syncListens(ctx context.Context, conn *pgx.Conn, listening map[string]struct{}) error {
+	b.mu.Lock()
+	var add, drop []string
+	for id := range b.docs {
+		if _, ok := listening[id]; !ok {
+			add = append(add, id)
+		}
+	}
+	for id := range listening {
+		if _, ok := b.docs[id]; !ok {
+			drop = append(drop, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, id := range add {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel(id)}.Sanitize()); err != nil {
+			return err
+		}
+		listening[id] = struct{}{}
+	}
+	for _, id := range drop {
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel(id)}.Sanitize()); err != nil {
+			return err
+		}
+		delete(listening, id)
+	}
+	return nil
+}
+
+// decode turns a notification payload into a message, fetching spilled bodies
+func (b *PostgresBus) decode(ctx context.Context, payload string) (BusMessage, error) {
+	raw := []byte(payload)
+	if rest, ok := strings.CutPrefix(payload, pgSpillPrefix); ok {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return BusMessage{}, err
+		}
+		if err := b.pool.QueryRow(ctx, `SELECT body FROM bus_spill WHERE id = $1`, id).Scan(&raw); err != nil {
+			return BusMessage{}, err
+		}
+	}
+	var bm BusMessage
+	err := json.Unmarshal(raw, &bm)
+	return bm, err
+}
+
+// expireSpill deletes spilled messages every listener has had time to read
+func (b *PostgresBus) expireSpill(ctx context.Context) {
+	t := time.NewTicker(pgSpillTTL)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			_, _ = b.pool.Exec(ctx, `DELETE FROM bus_spill WHERE created_at < $1`, time.Now().Add(-pgSpillTTL))
+		}
+	}
+}
+
+// Close shuts down the connection pool
+func (b *PostgresBus) Close() { b.pool.Close() }
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+
+	"realtime-docs/internal/store"
+	"realtime-docs/pkg/yjs"
+)
+
+// Wire protocol frame types (first byte of every frame)
+const (
+	msgUpdate    = 1 // Yjs incremental update
+	msgSyncReq   = 2 // sync request, payload is the sender's state vector (may be empty)
+	msgSyncRes   = 3 // full or missing state as a Yjs update
+	msgAwareness = 4 // awareness update
+	msgControl   = 5 // server control message, JSON payload
+)
+
+// control is the payload of a msgControl frame
+type control struct {
+	Type    string `json:"type"`              // "reset": drop local doc state and reconnect; "reconnect": server is going away; "editors"; "session"; "seq"
+	Version int64  `json:"version,omitempty"` // doc version after a reset
+	AfterMs int64  `json:"afterMs,omitempty"` // how long to wait before reconnecting
+
+	Editors []Editor `json:"editors,omitempty"` // "editors": who is editing, sent to viewers
+
+	// Multiplexed conns: "subscribe"/"unsubscribe" requests and their
+	// "subscribed"/"unsubscribed"/"error" replies on channel 0
+	Channel uint64     `json:"channel,omitempty"`
+	DocID   string     `json:"docId,omitempty"`
+	Role    store.Role `json:"role,omitempty"`
+	Error   string     `json:"error,omitempty"`
+
+	// Resume: "session" hands out the token, "seq" marks feed positions; a
+	// reconnecting client presents both (in the query or its "subscribe")
+	Token   string `json:"token,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
+	Resumed bool   `json:"resumed,omitempty"` // "session": only missed frames were sent, skip the sync handshake
+}
+
+// frame prepends the type byte to a payload
+func frame(typ byte, payload []byte) []byte {
+	b := make([]byte, 1+len(payload))
+	b[0] = typ
+	copy(b[1:], payload)
+	return b
+}
+
+// controlFrame encodes a control message as a frame
+func controlFrame(c control) []byte {
+	b, _ := json.Marshal(c)
+	return frame(msgControl, b)
+}
+
+// isControl reports whether b is a control frame
+func isControl(b []byte) bool { return len(b) > 0 && b[0] == msgControl }
+
+// parseControl decodes a control frame, ok is false for any other frame
+func parseControl(payload []byte) (c control, ok bool) {
+	if len(payload) < 2 || payload[0] != msgControl {
+		return c, false
+	}
+	return c, json.Unmarshal(payload[1:], &c) == nil
+}
+
+// Reasons inbound frames are rejected, also the labels of WSRejectedFrames
+var (
+	errEmptyFrame   = errors.New("empty")
+	errUnknownFrame = errors.New("unknown")
+	errServerOnly   = errors.New("server_only")
+	errBadPayload   = errors.New("invalid")
+)
+
+// inFrame is a decoded and validated frame from a client
+type inFrame struct {
+	typ       byte
+	payload   []byte
+	raw       []byte               // the encoded frame, relayed as is
+	awareness []yjs.AwarenessEntry // states of a msgAwareness frame
+}
+
+// decodeFrame splits a client frame into type and payload and checks the
+// payload is well-formed for its type
+func decodeFrame(b []byte) (inFrame, error) {
+	if len(b) == 0 {
+		return inFrame{}, errEmptyFrame
+	}
+	f := inFrame{typ: b[0], payload: b[1:], raw: b}
+	var err error
+	switch f.typ {
+	case msgUpdate, msgSyncRes:
+		if len(f.payload) == 0 {
+			return f, errBadPayload
+		}
+		err = yjs.Validate(f.payload)
+	case msgSyncReq:
+		_, err = yjs.DecodeStateVector(f.payload)
+	case msgAwareness:
+		f.awareness, err = yjs.DecodeAwareness(f.payload)
+	case msgControl:
+		return f, errServerOnly
+	default:
+		return f, errUnknownFrame
+	}
+	if err != nil {
+		return f, errBadPayload
+	}
+	return f, nil
+}
+
+// frameName labels a frame type in metrics and logs
+func frameName(typ byte) string {
+	switch typ {
+	case msgUpdate:
+		return "update"
+	case msgSyncReq:
+		return "sync_request"
+	case msgSyncRes:
+		return "sync_response"
+	case msgAwareness:
+		return "awareness"
+	case msgControl:
+		return "control"
+	}
+	return "unknown"
+}
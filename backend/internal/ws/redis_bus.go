@@ -9,14 +9,10 @@ import (
 	"realtime-docs/internal/app"
 )
 
-type BusMessage struct {
-	DocID   string `json:"docId"`
-	Payload []byte `json:"payload"`
-}
-
 type RedisBus struct {
-	rdb *redis.Client
-	log *slog.Logger
+	rdb    *redis.Client
+	log    *slog.Logger
+	pubsub *redis.PubSub // one connection, channels added per watched doc
 }
 
 // NewRedisBus connects to redis and verifies connectivity
@@ -28,7 +24,7 @@ func NewRedisBus(ctx context.Context, cfg app.Config, log *slog.Logger) (*RedisB
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		return nil, err
 	}
-	return &RedisBus{rdb: rdb, log: log}, nil
+	return &RedisBus{rdb: rdb, log: log, pubsub: rdb.Subscribe(ctx)}, nil
 }
 
 // Publish sends a message to the redis channel for a doc
@@ -37,17 +33,32 @@ func (b *RedisBus) Publish(ctx context.Context, m BusMessage) error {
 	return b.rdb.Publish(ctx, channel(m.DocID), raw).Err()
 }
 
-// Subscribe listens to all doc channels and invokes fn for each message
+// Watch subscribes to the doc's channel
+func (b *RedisBus) Watch(docID string) {
+	if err := b.pubsub.Subscribe(context.Background(), channel(docID)); err != nil {
+		b.log.Warn("bus.redis.subscribe", "doc", docID, "err", err)
+	}
+}
+
+// Unwatch unsubscribes from the doc's channel
+func (b *RedisBus) Unwatch(docID string) {
+	if err := b.pubsub.Unsubscribe(context.Background(), channel(docID)); err != nil {
+		b.log.Warn("bus.redis.unsubscribe", "doc", docID, "err", err)
+	}
+}
+
+// Subscribe invokes fn for each message on the watched doc channels
 func (b *RedisBus) Subscribe(ctx context.Context, fn func(BusMessage)) {
-	pubsub := b.rdb.PSubscribe(ctx, channel("*"))
-	ch := pubsub.Channel()
+	ch := b.pubsub.Channel()
 
 	for {
 		select {
 		case <-ctx.Done():
-			_ = pubsub.Close()
 			return
-		case msg := <-ch:
+		case msg, ok := <-ch:
+			if !ok {
+				return // closed
+			}
 			var bm BusMessage
 			_ = json.Unmarshal([]byte(msg.Payload), &bm)
 			if bm.DocID != "" {
@@ -57,8 +68,11 @@ func (b *RedisBus) Subscribe(ctx context.Context, fn func(BusMessage)) {
 	}
 }
 
-// Close shuts down the redis connection
-func (b *RedisBus) Close() { _ = b.rdb.Close() }
+// Close shuts down the redis connections
+func (b *RedisBus) Close() {
+	_ = b.pubsub.Close()
+	_ = b.rdb.Close()
+}
 
 // channel namespacing for doc pub/sub
 func channel(docID string) string { return "doc:" + docID }
@@ -0,0 +1,184 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"realtime-docs/internal/app"
+)
+
+// RedisStreamBus fans frames out through one Redis stream per doc. Unlike
+// Pub/Sub, entries stay in the stream (trimmed to about maxLen), so after a
+// reconnect or a slow read the subscriber resumes from its last offset and
+// replays what it missed. Offsets live in memory: replay covers connection
+// drops within a process's lifetime, a restarted instance loads its rooms
+// from storage instead. Streams of docs nobody writes to expire after ttl.
+type RedisStreamBus struct {
+	rdb    *redis.Client
+	log    *slog.Logger
+	maxLen int64
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	offsets map[string]string // docID -> last delivered stream entry ID
+	wake    chan struct{}     // signals the reader that the watch set changed
+}
+
+// streamBlock is how long one XREAD waits; new watches are picked up after it
+const streamBlock = time.Second
+
+// streamInfoTimeout bounds the lookup of a newly watched stream's last entry
+const streamInfoTimeout = 2 * time.Second
+
+// NewRedisStreamBus connects to redis and verifies connectivity
+func NewRedisStreamBus(ctx context.Context, cfg app.Config, log *slog.Logger) (*RedisStreamBus, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisAddr,
+		DB:   cfg.RedisDB,
+	})
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return &RedisStreamBus{
+		rdb:     rdb,
+		log:     log,
+		maxLen:  int64(cfg.RedisStreamMaxLen),
+		ttl:     cfg.RedisStreamTTL,
+		offsets: map[string]string{},
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Publish appends a message to the doc's stream, trimming it to about maxLen
+// and pushing its expiry back
+func (b *RedisStreamBus) Publish(ctx context.Context, m BusMessage) error {
+	raw, _ := json.Marshal(m)
+	_, err := b.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream(m.DocID),
+			MaxLen: b.maxLen,
+			Approx: true,
+			Values: map[string]any{"m": raw},
+		})
+		p.Expire(ctx, stream(m.DocID), b.ttl)
+		return nil
+	})
+	return err
+}
+
+// Watch starts delivering a doc's stream to Subscribe, after the stream's
+// current last entry as Redis sees it, so neither clock skew between
+// instances nor a publish right after the call loses an entry. A stream that
+// doesn't exist yet, or can't be read, is delivered from the start; replayed
+// frames are harmless since Yjs updates are idempotent.
+func (b *RedisStreamBus) Watch(docID string) {
+	start := "0-0"
+	ctx, cancel := context.WithTimeout(context.Background(), streamInfoTimeout)
+	last, err := b.rdb.XRevRangeN(ctx, stream(docID), "+", "-", 1).Result()
+	cancel()
+	if err != nil {
+		b.log.Warn("bus.stream.last", "doc", docID, "err", err)
+	} else if len(last) > 0 {
+		start = last[0].ID
+	}
+
+	b.mu.Lock()
+	if _, ok := b.offsets[docID]; !ok {
+		b.offsets[docID] = start
+	}
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Unwatch stops delivering a doc's stream and forgets its offset
+func (b *RedisStreamBus) Unwatch(docID string) {
+	b.mu.Lock()
+	delete(b.offsets, docID)
+	b.mu.Unlock()
+}
+
+// Subscribe reads all watched streams and invokes fn for each message.
+// Offsets survive read errors, so a reconnect replays missed entries.
+func (b *RedisStreamBus) Subscribe(ctx context.Context, fn func(BusMessage)) {
+	for ctx.Err() == nil {
+		args := b.readArgs()
+		if len(args) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.wake:
+			}
+			continue
+		}
+
+		res, err := b.rdb.XRead(ctx, &redis.XReadArgs{Streams: args, Count: 256, Block: streamBlock}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.log.Warn("bus.stream.read", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, s := range res {
+			docID := strings.TrimSuffix(strings.TrimPrefix(s.Stream, "doc:"), ":stream")
+			for _, e := range s.Messages {
+				if !b.advance(docID, e.ID) {
+					break // unwatched meanwhile
+				}
+				raw, _ := e.Values["m"].(string)
+				var bm BusMessage
+				_ = json.Unmarshal([]byte(raw), &bm)
+				if bm.DocID != "" {
+					fn(bm)
+				}
+			}
+		}
+	}
+}
+
+// readArgs builds XREAD's "keys... ids..." list from the watched docs
+func (b *RedisStreamBus) readArgs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, 2*len(b.offsets))
+	ids := make([]string, 0, len(b.offsets))
+	for docID, id := range b.offsets {
+		keys = append(keys, stream(docID))
+		ids = append(ids, id)
+	}
+	return append(keys, ids...)
+}
+
+// advance records id as delivered; false if the doc is no longer watched
+func (b *RedisStreamBus) advance(docID, id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.offsets[docID]; !ok {
+		return false
+	}
+	b.offsets[docID] = id
+	return true
+}
+
+// Close shuts down the redis connection
+func (b *RedisStreamBus) Close() { _ = b.rdb.Close() }
+
+// stream is the per-doc stream key
+func stream(docID string) string { return "doc:" + docID + ":stream" }
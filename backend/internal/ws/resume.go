@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// resumeSession is what a resume token stands for: a user's subscription to
+// a doc in one room of this instance. Frames are sequenced per room, so a
+// token is worthless on another instance or once the room was evicted.
+type resumeSession struct {
+	uid   string
+	docID string
+	rm    *Room
+	left  time.Time // when the subscription ended, zero while it is live
+}
+
+// resumePoint is where a resumed subscription continues in its room's feed
+type resumePoint struct {
+	rm  *Room
+	seq uint64
+}
+
+// newResumeToken returns a random, unguessable token
+func newResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// issueResume hands out the token m can be resumed with once its conn drops
+func (h *Hub) issueResume(m *Member) {
+	m.token = newResumeToken()
+	h.resumeMu.Lock()
+	h.resumes[m.token] = &resumeSession{uid: m.conn.uid, docID: m.docID, rm: m.rm}
+	h.resumeMu.Unlock()
+}
+
+// endResume starts the window in which m's subscription can be resumed
+func (h *Hub) endResume(m *Member) {
+	if m.token == "" {
+		return
+	}
+	h.resumeMu.Lock()
+	if s := h.resumes[m.token]; s != nil {
+		s.left = time.Now()
+	}
+	h.resumeMu.Unlock()
+	token := m.token
+	time.AfterFunc(h.cfg.ResumeWindow, func() {
+		h.resumeMu.Lock()
+		delete(h.resumes, token)
+		h.resumeMu.Unlock()
+	})
+}
+
+// takeResume redeems a token of uid for docID, nil when it is unknown, still
+// in use, expired or for someone else. Tokens are single use.
+func (h *Hub) takeResume(token, uid, docID string) *Room {
+	if token == "" {
+		return nil
+	}
+	h.resumeMu.Lock()
+	defer h.resumeMu.Unlock()
+	s := h.resumes[token]
+	if s == nil || s.left.IsZero() || time.Since(s.left) > h.cfg.ResumeWindow || s.uid != uid || s.docID != docID {
+		return nil
+	}
+	delete(h.resumes, token)
+	return s.rm
+}
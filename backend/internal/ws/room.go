@@ -1,40 +1,389 @@
 package ws
 
-import "sync"
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"realtime-docs/pkg/yjs"
+)
+
+// maxPending bounds how many updates are buffered before merging into the doc state
+const maxPending = 64
 
 type Room struct {
+	docID string
+	log   *slog.Logger
+
 	mu sync.RWMutex
-	clients map[*Conn]struct{} // active connections in this room
+	clients map[*Member]struct{} // active editor-tier members of this room
+	viewers map[*Member]struct{} // read-only members, served from feed
+	emptySince time.Time       // when the last connection left, zero while in use
+
+	loadMu sync.Mutex // serialises the initial load from storage
+	loaded bool
+
+	dirty     chan struct{} // signals the persister that the doc changed
+	done      chan struct{} // closed once the hub drops the room
+	closeOnce sync.Once
+	watched   chan struct{} // closed once the hub watches the doc on the bus
+
+	// counters since the last stats tick
+	applied   atomic.Int64
+	broadcast atomic.Int64
+
+	docMu   sync.Mutex
+	state   []byte   // merged Yjs update holding the whole document
+	pending [][]byte // updates applied since state was last merged
+	gen     int64    // storage generation state descends from, see store.SaveDoc
+
+	awareMu sync.Mutex
+	aware   map[uint64]clientState  // awareness of local conns' Yjs clients
+	remote  map[uint64]remoteClient // Yjs clients relayed from other instances
+
+	batch       awarenessBatch // awareness waiting for the next combined frame
+	lastEditors []byte         // editor list last sent to viewers, owned by RunAwareness
+
+	feed   *frameLog // broadcast frames in sequence, read by viewers and replayed on resume
+	marked uint64    // feed position last announced with a "seq" control
 }
 
-// NewRoom creates an empty room
-func NewRoom() *Room { return &Room{clients: map[*Conn]struct{}{}} }
+// NewRoom creates an empty room for a doc
+func NewRoom(docID string, log *slog.Logger) *Room {
+	return &Room{
+		docID:      docID,
+		log:        log,
+		clients:    map[*Member]struct{}{},
+		viewers:    map[*Member]struct{}{},
+		feed:       newFrameLog(frameLogSize),
+		emptySince: time.Now(),
+		aware:      map[uint64]clientState{},
+		remote:     map[uint64]remoteClient{},
+		batch:      newAwarenessBatch(),
+		dirty:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		watched: make(chan struct{}),
+	}
+}
 
-// Run is a placeholder could handle cleanup, ticks, etc
-func (r *Room) Run() {}
+// DocID returns the doc the room serves
+func (r *Room) DocID() string { return r.docID }
 
-// Join adds a connection to the room
-func (r *Room) Join(c *Conn) {
+// Run does the room's periodic work every tick until the room closes, and
+// calls onIdle once the room has been empty for idle
+func (r *Room) Run(tick, idle time.Duration, onIdle func(*Room)) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+			r.stats(tick)
+			r.markSeq()
+			if since := r.idleSince(); !since.IsZero() && time.Since(since) >= idle {
+				onIdle(r)
+			}
+		}
+	}
+}
+
+// stats logs the room's health since the last tick, if it saw any traffic
+func (r *Room) stats(tick time.Duration) {
+	applied, broadcast := r.applied.Swap(0), r.broadcast.Swap(0)
+	if applied == 0 && broadcast == 0 {
+		return
+	}
+	r.mu.RLock()
+	clients, viewers := len(r.clients), len(r.viewers)
+	r.mu.RUnlock()
+	r.docMu.Lock()
+	size, pending := len(r.state), len(r.pending)
+	r.docMu.Unlock()
+	r.log.Debug("room.stats", "doc", r.docID, "clients", clients, "viewers", viewers, "stateBytes", size, "pending", pending,
+		"applied", applied, "broadcast", broadcast, "window", tick)
+}
+
+// idleSince returns when the room became empty, zero if it has connections
+func (r *Room) idleSince() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.emptySince
+}
+
+// Join adds a member to the room. A resumed member gets the frames it missed
+// from the feed; any other starts at the feed's current end, the state it
+// needs comes with the initial sync.
+func (r *Room) Join(m *Member) {
 	r.mu.Lock()
-	r.clients[c] = struct{}{}
+	if m.resume != nil && !r.replay(m) {
+		m.resume = nil
+	}
+	if m.resume == nil {
+		m.seq = r.feed.next()
+	}
+	if m.viewer {
+		r.viewers[m] = struct{}{}
+	} else {
+		r.clients[m] = struct{}{}
+	}
+	r.emptySince = time.Time{}
 	r.mu.Unlock()
 }
 
-// Leave removes a connection from the room
-func (r *Room) Leave(c *Conn) {
+// replay queues the frames a resuming member missed, false when they are no
+// longer in the feed or the member left another room. Viewers read them from
+// the feed themselves. Caller holds r.mu, so no broadcast slips in between.
+func (r *Room) replay(m *Member) bool {
+	if m.resume.rm != r {
+		return false
+	}
+	frames, next, ok := r.feed.since(m.resume.seq)
+	if !ok {
+		return false
+	}
+	if m.viewer {
+		m.seq = m.resume.seq
+		return true
+	}
+	for _, b := range frames {
+		if c, ok := parseControl(b); ok && c.Type == "editors" {
+			continue // for viewers only
+		}
+		m.Send(b)
+	}
+	m.seq = next
+	return true
+}
+
+// markSeq tells every member how far the feed has come, which is where a
+// client that drops continues from. Broadcasts are held off meanwhile, so
+// every earlier frame is queued ahead of the mark.
+func (r *Room) markSeq() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.feed.next() == r.marked || len(r.clients)+len(r.viewers) == 0 {
+		return
+	}
+	r.broadcastLocked(controlFrame(control{Type: "seq", Seq: r.feed.next() + 1}), nil)
+	r.marked = r.feed.next()
+}
+
+// Leave removes a member from the room
+func (r *Room) Leave(m *Member) {
 	r.mu.Lock()
-	delete(r.clients, c)
+	delete(r.clients, m)
+	delete(r.viewers, m)
+	if len(r.clients)+len(r.viewers) == 0 && r.emptySince.IsZero() {
+		r.emptySince = time.Now()
+	}
 	r.mu.Unlock()
 }
 
-// Broadcast sends a message to all connections without blocking
-func (r *Room) Broadcast(b []byte) {
+// Count returns the number of editor-tier and viewer members
+func (r *Room) Count() (editors, viewers int) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	for c := range r.clients {
-		select {
-		case c.out <- b:
-		default: // skip if send buffer is full
+	return len(r.clients), len(r.viewers)
+}
+
+// MarkDirty tells the persister the doc changed, without blocking
+func (r *Room) MarkDirty() {
+	select {
+	case r.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Dirty returns the channel the persister waits on for changes
+func (r *Room) Dirty() <-chan struct{} { return r.dirty }
+
+// Close marks the room as dropped by the hub; the persister flushes and exits
+func (r *Room) Close() { r.closeOnce.Do(func() { close(r.done) }) }
+
+// Done is closed once the room is closed
+func (r *Room) Done() <-chan struct{} { return r.done }
+
+// Members returns a snapshot of the room's members
+func (r *Room) Members() []*Member {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Member, 0, len(r.clients)+len(r.viewers))
+	for m := range r.clients {
+		out = append(out, m)
+	}
+	for m := range r.viewers {
+		out = append(out, m)
+	}
+	return out
+}
+
+// Conns returns a snapshot of the room's connections
+func (r *Room) Conns() []*Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Conn, 0, len(r.clients)+len(r.viewers))
+	for m := range r.clients {
+		out = append(out, m.conn)
+	}
+	for m := range r.viewers {
+		out = append(out, m.conn)
+	}
+	return out
+}
+
+// Empty reports whether no members are left
+func (r *Room) Empty() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.clients)+len(r.viewers) == 0
+}
+
+// Broadcast sends a message to all members except the sender (nil for
+// server frames) without blocking; slow consumers are resynced by their conn.
+// Every frame is sequenced in the room's feed, through which viewers share a
+// single copy.
+func (r *Room) Broadcast(b []byte, except *Member) {
+	r.broadcast.Add(1)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.broadcastLocked(b, except)
+}
+
+// broadcastLocked appends to the feed and sends; caller holds r.mu
+func (r *Room) broadcastLocked(b []byte, except *Member) {
+	r.feed.append(b)
+	for m := range r.clients {
+		if m != except {
+			m.Send(b)
 		}
 	}
+	for m := range r.viewers {
+		m.conn.wake()
+	}
+}
+
+// sendEditors sends a message to the editor-tier members only
+func (r *Room) sendEditors(b []byte, except *Member) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for m := range r.clients {
+		if m != except {
+			m.Send(b)
+		}
+	}
+}
+
+// Load seeds the document from storage once; later calls are no-ops.
+// Updates applied before the load are kept, merging is order independent.
+func (r *Room) Load(load func() ([]byte, int64, error)) error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	if r.loaded {
+		return nil
+	}
+	b, gen, err := load()
+	if err != nil {
+		return err
+	}
+	if _, err := r.Apply(b); err != nil {
+		return err
+	}
+	r.docMu.Lock()
+	r.gen = gen
+	r.docMu.Unlock()
+	r.loaded = true
+	return nil
+}
+
+// Apply merges a Yjs update into the room's document and returns the
+// storage generation it was merged at
+func (r *Room) Apply(update []byte) (int64, error) {
+	if err := yjs.Validate(update); err != nil {
+		return 0, err
+	}
+	r.applied.Add(1)
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+	r.pending = append(r.pending, update)
+	if len(r.pending) >= maxPending {
+		return r.gen, r.compactLocked()
+	}
+	return r.gen, nil
+}
+
+// Reset replaces the document with the state returned by fn, e.g. after a
+// version restore, along with its storage generation. Updates wait while fn
+// runs.
+func (r *Room) Reset(fn func() ([]byte, int64, error)) error {
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+	state, gen, err := fn()
+	if err != nil {
+		return err
+	}
+	r.state, r.pending, r.gen = state, nil, gen
+	return nil
+}
+
+// State returns the whole document as a single Yjs update
+func (r *Room) State() ([]byte, error) {
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+	if err := r.compactLocked(); err != nil {
+		return nil, err
+	}
+	return r.state, nil
+}
+
+// Snapshot returns the whole document with the storage generation it
+// descends from, for saving
+func (r *Room) Snapshot() ([]byte, int64, error) {
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+	if err := r.compactLocked(); err != nil {
+		return nil, 0, err
+	}
+	return r.state, r.gen, nil
+}
+
+// StateVector returns the encoded state vector of the document
+func (r *Room) StateVector() ([]byte, error) {
+	state, err := r.State()
+	if err != nil {
+		return nil, err
+	}
+	sv, err := yjs.StateVectorFromUpdate(state)
+	if err != nil {
+		return nil, err
+	}
+	return sv.Encode(), nil
+}
+
+// SyncReply returns what a peer with encoded state vector sv is missing.
+// An empty state vector gets the full state.
+func (r *Room) SyncReply(sv []byte) ([]byte, error) {
+	state, err := r.State()
+	if err != nil || len(sv) == 0 {
+		return state, err
+	}
+	vec, err := yjs.DecodeStateVector(sv)
+	if err != nil {
+		return nil, err
+	}
+	return yjs.DiffUpdate(state, vec)
+}
+
+// compactLocked folds pending updates into state; caller holds docMu
+func (r *Room) compactLocked() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	merged, err := yjs.MergeUpdates(append([][]byte{r.state}, r.pending...)...)
+	if err != nil {
+		return err
+	}
+	r.state, r.pending = merged, nil
+	return nil
 }
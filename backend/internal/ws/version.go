@@ -0,0 +1,130 @@
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nhooyr.io/websocket"
+)
+
+// Protocol versions, negotiated through the WebSocket subprotocol. The rest
+// of the server speaks v1; frames of other versions are translated at the conn.
+const (
+	protoV1 = 1 // [type byte][payload], see protocol.go
+	protoV2 = 2 // y-websocket layout: varUint message type, varUint8Array payloads
+)
+
+// subprotocols are selected in order of preference. "rtdocs" is what clients
+// sent before versioning and means v1.
+var subprotocols = []string{"rtdocs.v2", "rtdocs.v1", "rtdocs"}
+
+// StatusUnsupportedProtocol closes a client that offered no known protocol version
+const StatusUnsupportedProtocol websocket.StatusCode = 4002
+
+// protoVersion maps a negotiated subprotocol to its version, 0 if unsupported
+func protoVersion(subprotocol string) int {
+	switch subprotocol {
+	case "rtdocs.v2":
+		return protoV2
+	case "rtdocs.v1", "rtdocs":
+		return protoV1
+	}
+	return 0
+}
+
+// negotiatedVersion returns the protocol version of a connection accepted
+// with the selected subprotocol, 0 if the client only offered unknown ones.
+// Clients from before versioning offer none (a bearer token aside) and
+// speak v1.
+func negotiatedVersion(r *http.Request, selected string) int {
+	if v := protoVersion(selected); v != 0 {
+		return v
+	}
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" && !strings.HasPrefix(p, "bearer.") {
+				return 0
+			}
+		}
+	}
+	return protoV1
+}
+
+// v2 message types and sync steps (y-protocols), plus our control extension
+const (
+	v2Sync      = 0
+	v2Awareness = 1
+	v2Control   = 100 // JSON control message as varString
+
+	v2SyncStep1  = 0 // state vector
+	v2SyncStep2  = 1 // missing state
+	v2SyncUpdate = 2 // incremental update
+)
+
+var errV2Frame = errors.New("ws: malformed v2 frame")
+
+// toV2 translates a v1 frame to the v2 layout
+func toV2(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	var out []byte
+	p := b[1:]
+	switch b[0] {
+	case msgUpdate:
+		out = append(binary.AppendUvarint(out, v2Sync), v2SyncUpdate)
+	case msgSyncReq:
+		out = append(binary.AppendUvarint(out, v2Sync), v2SyncStep1)
+	case msgSyncRes:
+		out = append(binary.AppendUvarint(out, v2Sync), v2SyncStep2)
+	case msgAwareness:
+		out = binary.AppendUvarint(out, v2Awareness)
+	case msgControl:
+		out = binary.AppendUvarint(out, v2Control)
+	default:
+		return b
+	}
+	out = binary.AppendUvarint(out, uint64(len(p)))
+	return append(out, p...)
+}
+
+// fromV2 translates a v2 frame to the v1 layout
+func fromV2(b []byte) ([]byte, error) {
+	typ, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errV2Frame
+	}
+	b = b[n:]
+	var v1 byte
+	switch typ {
+	case v2Sync:
+		step, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errV2Frame
+		}
+		b = b[n:]
+		switch step {
+		case v2SyncStep1:
+			v1 = msgSyncReq
+		case v2SyncStep2:
+			v1 = msgSyncRes
+		case v2SyncUpdate:
+			v1 = msgUpdate
+		default:
+			return nil, errV2Frame
+		}
+	case v2Awareness:
+		v1 = msgAwareness
+	case v2Control:
+		v1 = msgControl
+	default:
+		return nil, errV2Frame
+	}
+	size, n := binary.Uvarint(b)
+	if n <= 0 || size != uint64(len(b)-n) {
+		return nil, errV2Frame
+	}
+	return frame(v1, b[n:]), nil
+}
@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestV2RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		v1   []byte
+		v2   []byte
+	}{
+		{"update", []byte{msgUpdate, 0xaa, 0xbb}, []byte{v2Sync, v2SyncUpdate, 2, 0xaa, 0xbb}},
+		{"sync request", []byte{msgSyncReq, 0x01}, []byte{v2Sync, v2SyncStep1, 1, 0x01}},
+		{"empty sync request", []byte{msgSyncReq}, []byte{v2Sync, v2SyncStep1, 0}},
+		{"sync response", []byte{msgSyncRes, 0x01, 0x02}, []byte{v2Sync, v2SyncStep2, 2, 0x01, 0x02}},
+		{"awareness", []byte{msgAwareness, 0x07}, []byte{v2Awareness, 1, 0x07}},
+		{"control", []byte{msgControl, '{', '}'}, []byte{v2Control, 2, '{', '}'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toV2(tt.v1); !bytes.Equal(got, tt.v2) {
+				t.Fatalf("toV2 = % x, want % x", got, tt.v2)
+			}
+			got, err := fromV2(tt.v2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.v1) {
+				t.Fatalf("fromV2 = % x, want % x", got, tt.v1)
+			}
+		})
+	}
+}
+
+func TestV2LongPayload(t *testing.T) {
+	p := bytes.Repeat([]byte{0x42}, 300)
+	v2 := toV2(append([]byte{msgUpdate}, p...))
+	if want := []byte{v2Sync, v2SyncUpdate, 0xac, 0x02}; !bytes.Equal(v2[:4], want) {
+		t.Fatalf("header = % x, want % x", v2[:4], want)
+	}
+	v1, err := fromV2(v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v1[1:], p) || v1[0] != msgUpdate {
+		t.Fatal("payload changed in the round trip")
+	}
+}
+
+func TestToV2UnknownPassesThrough(t *testing.T) {
+	for _, b := range [][]byte{nil, {}, {0x09, 0x01}} {
+		if got := toV2(b); !bytes.Equal(got, b) {
+			t.Fatalf("toV2(% x) = % x", b, got)
+		}
+	}
+}
+
+func TestFromV2Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"unknown type", []byte{0x07, 0}},
+		{"sync without step", []byte{v2Sync}},
+		{"unknown step", []byte{v2Sync, 0x05, 0}},
+		{"missing length", []byte{v2Awareness}},
+		{"short payload", []byte{v2Awareness, 3, 0x01}},
+		{"trailing bytes", []byte{v2Awareness, 1, 0x01, 0x02}},
+		{"truncated varint", []byte{0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fromV2(tt.b); err != errV2Frame {
+				t.Fatalf("err = %v, want errV2Frame", err)
+			}
+		})
+	}
+}
+
+func TestNegotiatedVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  []string
+		selected string
+		want     int
+	}{
+		{"v2", []string{"rtdocs.v2, rtdocs.v1"}, "rtdocs.v2", protoV2},
+		{"v1", []string{"rtdocs.v1"}, "rtdocs.v1", protoV1},
+		{"legacy name", []string{"rtdocs"}, "rtdocs", protoV1},
+		{"nothing offered", nil, "", protoV1},
+		{"bearer only", []string{"bearer.abc"}, "", protoV1},
+		{"unknown", []string{"rtdocs.v9"}, "", 0},
+		{"unknown with bearer", []string{"bearer.abc", "rtdocs.v9"}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/ws", nil)
+			for _, p := range tt.offered {
+				r.Header.Add("Sec-WebSocket-Protocol", p)
+			}
+			if got := negotiatedVersion(r, tt.selected); got != tt.want {
+				t.Fatalf("negotiatedVersion = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,7 +10,10 @@ import (
 
 type ctxKey int
 
-const userKey ctxKey = 1
+const (
+	userKey  ctxKey = 1
+	shareKey ctxKey = 2
+)
 
 // WithUser adds a user ID to the context
 func WithUser(ctx context.Context, uid string) context.Context {
@@ -26,26 +29,68 @@ func UserID(ctx context.Context) string {
 	return v.(string)
 }
 
+// WithShareLink marks the context as opened through a share link
+func WithShareLink(ctx context.Context, linkID string) context.Context {
+	return context.WithValue(ctx, shareKey, linkID)
+}
+
+// ShareLinkID returns the share link the request came through, or ""
+func ShareLinkID(ctx context.Context) string {
+	v, _ := ctx.Value(shareKey).(string)
+	return v
+}
+
 // JWT wraps a signing secret for issuing/verifying tokens
 type JWT struct{ secret []byte }
 
 // New creates a new JWT signer/verifier.
 func New(secret string) *JWT { return &JWT{secret: []byte(secret)} }
 
+// Audiences of short-lived tickets, which are not API tokens
+const (
+	ticketAudience = "ws"    // WebSocket ticket for a user
+	shareAudience  = "share" // WebSocket ticket for a resolved share link
+)
+
 // Verify checks a token and returns the sub (user ID) claim
 func (j *JWT) Verify(tok string) (string, error) {
+	claims, uid, err := j.parse(tok)
+	if err != nil {
+		return "", err
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 0 {
+		return "", errors.New("not an api token")
+	}
+	return uid, nil
+}
+
+// VerifyTicket checks a WebSocket ticket and returns its user ID
+func (j *JWT) VerifyTicket(tok string) (string, error) {
+	_, uid, err := j.parse(tok, jwt.WithAudience(ticketAudience), jwt.WithExpirationRequired())
+	return uid, err
+}
+
+// VerifyShareTicket checks a share ticket and returns its share link ID
+func (j *JWT) VerifyShareTicket(tok string) (string, error) {
+	_, linkID, err := j.parse(tok, jwt.WithAudience(shareAudience), jwt.WithExpirationRequired())
+	return linkID, err
+}
+
+// parse validates the signature and standard claims and extracts sub
+func (j *JWT) parse(tok string, opts ...jwt.ParserOption) (jwt.MapClaims, string, error) {
 	claims := jwt.MapClaims{}
+	opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	_, err := jwt.ParseWithClaims(tok, claims, func(token *jwt.Token) (interface{}, error) {
 		return j.secret, nil
-	})
+	}, opts...)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 	uid, _ := claims["sub"].(string)
 	if uid == "" {
-		return "", errors.New("no sub")
+		return nil, "", errors.New("no sub")
 	}
-	return uid, nil
+	return claims, uid, nil
 }
 
 // Sign creates a token for uid with the given TTL
@@ -61,3 +106,34 @@ func (j *JWT) Sign(uid string, ttl time.Duration) (string, error) {
 	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return tok.SignedString(j.secret)
 }
+
+// SignTicket creates a short-lived token that only opens WebSocket connections
+func (j *JWT) SignTicket(uid string, ttl time.Duration) (string, error) {
+	if uid == "" {
+		return "", errors.New("empty uid")
+	}
+	claims := jwt.MapClaims{
+		"sub": uid,
+		"aud": ticketAudience,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(ttl).Unix(),
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tok.SignedString(j.secret)
+}
+
+// SignShareTicket creates a short-lived token that opens WebSocket
+// connections through a share link
+func (j *JWT) SignShareTicket(linkID string, ttl time.Duration) (string, error) {
+	if linkID == "" {
+		return "", errors.New("empty link id")
+	}
+	claims := jwt.MapClaims{
+		"sub": linkID,
+		"aud": shareAudience,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(ttl).Unix(),
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return tok.SignedString(j.secret)
+}
@@ -3,9 +3,141 @@ package metrics
 import (
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// BusSubscriptions counts the docs this instance receives bus traffic for
+var BusSubscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "rtdocs_bus_subscriptions",
+	Help: "Docs this instance is subscribed to on the bus.",
+})
+
+// BusSubscribeOps counts subscribe/unsubscribe calls by op
+var BusSubscribeOps = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_bus_subscribe_ops_total",
+	Help: "Per-doc bus subscribe and unsubscribe operations.",
+}, []string{"op"})
+
+// BusDropped counts in-process bus messages dropped for a subscriber that fell behind
+var BusDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rtdocs_bus_dropped_total",
+	Help: "In-process bus messages dropped because a subscriber's buffer was full.",
+})
+
+// Rooms counts open doc rooms, including empty ones waiting for eviction
+var Rooms = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "rtdocs_rooms",
+	Help: "Open document rooms.",
+})
+
+// RoomEvictions counts rooms dropped after being idle
+var RoomEvictions = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rtdocs_room_evictions_total",
+	Help: "Rooms evicted after being empty for the idle timeout.",
+})
+
+// WSConns counts open WebSocket doc subscriptions by tier (editor, viewer);
+// a multiplexed connection counts once per doc
+var WSConns = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rtdocs_ws_conns",
+	Help: "Open WebSocket doc subscriptions by tier.",
+}, []string{"tier"})
+
+// WSDroppedFrames counts frames not queued because a client fell behind
+var WSDroppedFrames = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rtdocs_ws_dropped_frames_total",
+	Help: "Outbound frames dropped for slow WebSocket clients.",
+})
+
+// WSResyncs counts slow clients brought back in sync, by how (full_state, disconnect)
+var WSResyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_resyncs_total",
+	Help: "Forced resyncs of slow WebSocket clients.",
+}, []string{"how"})
+
+// WSTimeouts counts connections torn down for a write deadline or missing pong
+var WSTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_timeouts_total",
+	Help: "WebSocket connections torn down by kind of timeout (write, pong).",
+}, []string{"kind"})
+
+// WSLimitViolations counts connections closed for exceeding an inbound limit,
+// by kind (frames, bytes, frame_size) and scope (conn, user)
+var WSLimitViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_limit_violations_total",
+	Help: "WebSocket connections closed for exceeding inbound rate or size limits.",
+}, []string{"kind", "scope"})
+
+// WSResumes counts subscriptions that presented a resume token, by result
+// (replayed, full_sync when the token or the frames were gone)
+var WSResumes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_resumes_total",
+	Help: "WebSocket resume attempts by result.",
+}, []string{"result"})
+
+// WSConnections counts accepted WebSocket connections by negotiated
+// subprotocol, "none" for clients from before versioning (served v1) and
+// "unsupported" for clients turned away
+var WSConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_connections_total",
+	Help: "WebSocket connections by negotiated protocol.",
+}, []string{"protocol"})
+
+// WSFrames counts accepted inbound frames by type
+var WSFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_frames_total",
+	Help: "Inbound WebSocket frames accepted, by frame type.",
+}, []string{"type"})
+
+// WSRejectedFrames counts inbound frames dropped by reason (empty, unknown,
+// server_only, invalid, denied, unknown_channel)
+var WSRejectedFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_ws_rejected_frames_total",
+	Help: "Inbound WebSocket frames rejected, by reason.",
+}, []string{"reason"})
+
+// AwarenessFrames counts awareness frames folded into batches (received) and
+// the combined frames broadcast (sent)
+var AwarenessFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_awareness_frames_total",
+	Help: "Awareness frames coalesced per room, by stage (received, sent).",
+}, []string{"stage"})
+
+// AwarenessSavedSends counts per-connection sends avoided by coalescing
+var AwarenessSavedSends = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rtdocs_awareness_saved_sends_total",
+	Help: "Awareness frame sends to connections saved by coalescing.",
+})
+
+// AwarenessWindowSeconds tracks the coalescing window rooms pick for their size
+var AwarenessWindowSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "rtdocs_awareness_window_seconds",
+	Help:    "Awareness coalescing window used per batch.",
+	Buckets: []float64{.025, .05, .1, .2, .3, .5, 1},
+})
+
+// DocSaves counts persister saves by result (ok, error, skipped without the
+// lease, stale when the doc was restored since the room loaded it)
+var DocSaves = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rtdocs_doc_saves_total",
+	Help: "Document saves by result.",
+}, []string{"result"})
+
+// DocSaveSeconds tracks how long SaveDoc takes
+var DocSaveSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "rtdocs_doc_save_seconds",
+	Help:    "Latency of document saves.",
+	Buckets: prometheus.DefBuckets,
+})
+
+// DocSaveBytes counts document state bytes written by saves
+var DocSaveBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "rtdocs_doc_save_bytes_total",
+	Help: "Document state bytes written by saves.",
+})
+
 // Handler exposes Prometheus metrics at /metrics
 func Handler() http.Handler {
 	return promhttp.Handler()
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled continuously at rate tokens per second,
+// holding at most burst tokens. Safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket; a rate <= 0 means unlimited
+func NewBucket(rate, burst float64) *Bucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &Bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Allow takes n tokens if available
+func (b *Bucket) Allow(n float64) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Refund returns n tokens taken by an Allow that turned out not to count
+func (b *Bucket) Refund(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// Keyed hands out one set of buckets per key (e.g. per user). Sets are kept
+// while anyone holds them and dropped once released for longer than idle.
+type Keyed[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*keyedEntry[T]
+	newFn   func() T
+	idle    time.Duration
+	swept   time.Time
+}
+
+type keyedEntry[T any] struct {
+	v    T
+	refs int       // holders that haven't released it
+	used time.Time // last acquired or released
+}
+
+// NewKeyed creates a registry building a fresh value with newFn per key
+func NewKeyed[T any](newFn func() T, idle time.Duration) *Keyed[T] {
+	return &Keyed[T]{entries: map[string]*keyedEntry[T]{}, newFn: newFn, idle: idle, swept: time.Now()}
+}
+
+// Acquire returns the value for key, creating it on first use. It stays
+// shared by every holder until each has called Release.
+func (k *Keyed[T]) Acquire(key string) T {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if now.Sub(k.swept) > k.idle {
+		for key, e := range k.entries {
+			if e.refs == 0 && now.Sub(e.used) > k.idle {
+				delete(k.entries, key)
+			}
+		}
+		k.swept = now
+	}
+	e := k.entries[key]
+	if e == nil {
+		e = &keyedEntry[T]{v: k.newFn()}
+		k.entries[key] = e
+	}
+	e.refs++
+	e.used = now
+	return e.v
+}
+
+// Release gives back a value taken with Acquire
+func (k *Keyed[T]) Release(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e := k.entries[key]; e != nil && e.refs > 0 {
+		e.refs--
+		e.used = time.Now()
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// rewind pretends d passed since the bucket last refilled
+func rewind(b *Bucket, d time.Duration) {
+	b.mu.Lock()
+	b.last = b.last.Add(-d)
+	b.mu.Unlock()
+}
+
+type step struct {
+	after time.Duration // time passed before the call
+	n     float64
+	ok    bool
+}
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate, burst float64
+		steps       []step
+	}{
+		{"starts full", 2, 5, []step{{0, 5, true}, {0, 1, false}}},
+		{"refills at rate", 2, 5, []step{{0, 5, true}, {time.Second, 2, true}, {0, 1, false}}},
+		{"capped at burst", 2, 5, []step{{0, 5, true}, {time.Hour, 5, true}, {0, 1, false}}},
+		{"burst at least rate", 10, 1, []step{{0, 10, true}, {0, 1, false}}},
+		{"more than burst never fits", 2, 5, []step{{time.Hour, 6, false}}},
+		{"failed take keeps tokens", 2, 5, []step{{0, 6, false}, {0, 5, true}}},
+		{"unlimited", 0, 0, []step{{0, 1e9, true}, {0, 1e9, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBucket(tt.rate, tt.burst)
+			for i, s := range tt.steps {
+				rewind(b, s.after)
+				if got := b.Allow(s.n); got != s.ok {
+					t.Fatalf("step %d: Allow(%v) = %v, want %v", i, s.n, got, s.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketRefund(t *testing.T) {
+	b := NewBucket(2, 5)
+	if !b.Allow(4) {
+		t.Fatal("Allow(4) on a full bucket failed")
+	}
+	b.Refund(3)
+	if !b.Allow(4) {
+		t.Fatal("refunded tokens weren't returned")
+	}
+	b.Refund(100)
+	if b.Allow(6) {
+		t.Fatal("refund filled past burst")
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	idle := time.Minute
+	k := NewKeyed(func() *Bucket { return NewBucket(10, 10) }, idle)
+	// expire makes every entry look released for longer than idle
+	expire := func() {
+		k.mu.Lock()
+		for _, e := range k.entries {
+			e.used = e.used.Add(-2 * idle)
+		}
+		k.swept = k.swept.Add(-2 * idle)
+		k.mu.Unlock()
+	}
+
+	a := k.Acquire("a")
+	if k.Acquire("a") != a {
+		t.Fatal("holders of a key got different values")
+	}
+	if k.Acquire("b") == a {
+		t.Fatal("different keys share a value")
+	}
+
+	// "a" is held twice: one release keeps it through a sweep
+	k.Release("a")
+	k.Release("b")
+	expire()
+	k.Acquire("c")
+	if k.Acquire("a") != a {
+		t.Fatal("a held value was swept")
+	}
+	k.Release("a")
+	k.mu.Lock()
+	_, kept := k.entries["b"]
+	k.mu.Unlock()
+	if kept {
+		t.Fatal("released idle value was kept")
+	}
+
+	// Fully released, "a" is swept and rebuilt on the next use
+	k.Release("a")
+	k.Release("a") // extra releases are ignored
+	expire()
+	if k.Acquire("a") == a {
+		t.Fatal("released idle value was kept")
+	}
+}
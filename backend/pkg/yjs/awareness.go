@@ -0,0 +1,58 @@
+package yjs
+
+import "encoding/json"
+
+// AwarenessEntry is one client's state in a y-protocols awareness update.
+// A "null" state means the client went away.
+type AwarenessEntry struct {
+	ClientID uint64
+	Clock    uint64
+	State    json.RawMessage
+}
+
+// Removed reports whether the entry removes the client's state
+func (e AwarenessEntry) Removed() bool { return string(e.State) == "null" }
+
+// DecodeAwareness parses an awareness update; every state must be valid JSON
+func DecodeAwareness(b []byte) ([]AwarenessEntry, error) {
+	d := newDecoder(b)
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	var out []AwarenessEntry
+	for i := uint64(0); i < n; i++ {
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		state, err := d.readVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(state) {
+			return nil, ErrMalformed
+		}
+		out = append(out, AwarenessEntry{ClientID: client, Clock: clock, State: json.RawMessage(state)})
+	}
+	if d.more() {
+		return nil, ErrMalformed
+	}
+	return out, nil
+}
+
+// EncodeAwareness writes entries as an awareness update
+func EncodeAwareness(entries []AwarenessEntry) []byte {
+	var e encoder
+	e.writeVarUint(uint64(len(entries)))
+	for _, a := range entries {
+		e.writeVarUint(a.ClientID)
+		e.writeVarUint(a.Clock)
+		e.writeVarBytes(a.State)
+	}
+	return e.buf
+}
@@ -0,0 +1,162 @@
+package yjs
+
+import (
+	"errors"
+	"unicode/utf16"
+)
+
+// ErrMalformed is returned for truncated or otherwise invalid Yjs/lib0 input
+var ErrMalformed = errors.New("yjs: malformed update")
+
+// decoder reads lib0 primitives from a byte slice
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func newDecoder(b []byte) *decoder { return &decoder{buf: b} }
+
+// more reports whether unread bytes remain
+func (d *decoder) more() bool { return d.pos < len(d.buf) }
+
+func (d *decoder) readUint8() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, ErrMalformed
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+// readVarUint reads an unsigned LEB128 integer (lib0 varUint)
+func (d *decoder) readVarUint() (uint64, error) {
+	var n uint64
+	var shift uint
+	for {
+		b, err := d.readUint8()
+		if err != nil {
+			return 0, err
+		}
+		n |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return n, nil
+		}
+		shift += 7
+		if shift > 63 {
+			return 0, ErrMalformed
+		}
+	}
+}
+
+// skipVarInt consumes a lib0 signed varInt without decoding it
+func (d *decoder) skipVarInt() error {
+	for {
+		b, err := d.readUint8()
+		if err != nil {
+			return err
+		}
+		if b < 0x80 {
+			return nil
+		}
+	}
+}
+
+func (d *decoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, ErrMalformed
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readVarBytes reads a length-prefixed byte array (lib0 varUint8Array)
+func (d *decoder) readVarBytes() ([]byte, error) {
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return d.readBytes(n)
+}
+
+// readVarString reads a length-prefixed utf8 string
+func (d *decoder) readVarString() (string, error) {
+	b, err := d.readVarBytes()
+	return string(b), err
+}
+
+// skipAny consumes one value in lib0 "any" encoding
+func (d *decoder) skipAny() error {
+	t, err := d.readUint8()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case 127, 126, 121, 120: // undefined, null, false, true
+		return nil
+	case 125: // integer
+		return d.skipVarInt()
+	case 124: // float32
+		_, err = d.readBytes(4)
+	case 123, 122: // float64, bigint64
+		_, err = d.readBytes(8)
+	case 119: // string
+		_, err = d.readVarBytes()
+	case 118: // object
+		n, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := d.readVarBytes(); err != nil {
+				return err
+			}
+			if err := d.skipAny(); err != nil {
+				return err
+			}
+		}
+	case 117: // array
+		n, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := d.skipAny(); err != nil {
+				return err
+			}
+		}
+	case 116: // Uint8Array
+		_, err = d.readVarBytes()
+	default:
+		return ErrMalformed
+	}
+	return err
+}
+
+// encoder appends lib0 primitives to a growing buffer
+type encoder struct{ buf []byte }
+
+func (e *encoder) writeUint8(b byte) { e.buf = append(e.buf, b) }
+
+func (e *encoder) writeVarUint(n uint64) {
+	for n >= 0x80 {
+		e.buf = append(e.buf, byte(n)|0x80)
+		n >>= 7
+	}
+	e.buf = append(e.buf, byte(n))
+}
+
+func (e *encoder) writeBytes(b []byte) { e.buf = append(e.buf, b...) }
+
+func (e *encoder) writeVarBytes(b []byte) {
+	e.writeVarUint(uint64(len(b)))
+	e.writeBytes(b)
+}
+
+func (e *encoder) writeVarString(s string) { e.writeVarBytes([]byte(s)) }
+
+// toUTF16 converts a utf8 string into the UTF-16 code units Yjs counts in
+func toUTF16(s string) []uint16 { return utf16.Encode([]rune(s)) }
+
+// fromUTF16 converts UTF-16 code units back to utf8 (lone surrogates become U+FFFD)
+func fromUTF16(u []uint16) string { return string(utf16.Decode(u)) }
@@ -0,0 +1,181 @@
+package yjs
+
+import "sort"
+
+// blockReader walks decoded blocks, optionally hiding Skips (Yjs' LazyStructReader)
+type blockReader struct {
+	blocks      []*block
+	i           int
+	filterSkips bool
+}
+
+func newBlockReader(blocks []*block, filterSkips bool) *blockReader {
+	r := &blockReader{blocks: blocks, filterSkips: filterSkips}
+	r.skip()
+	return r
+}
+
+func (r *blockReader) curr() *block {
+	if r.i < len(r.blocks) {
+		return r.blocks[r.i]
+	}
+	return nil
+}
+
+func (r *blockReader) next() *block {
+	r.i++
+	r.skip()
+	return r.curr()
+}
+
+func (r *blockReader) skip() {
+	for r.filterSkips && r.i < len(r.blocks) && r.blocks[r.i].kind == kindSkip {
+		r.i++
+	}
+}
+
+// mergeWith extends b by r when both are GCs or Skips. Decoded items never
+// merge, same as in Yjs' update merging.
+func (b *block) mergeWith(r *block) bool {
+	if b.kind != r.kind || b.kind == kindItem {
+		return false
+	}
+	b.length += r.length
+	return true
+}
+
+// MergeUpdates combines v1 updates into a single update without needing a
+// document, following Yjs' mergeUpdates. The result holds everything any of
+// the inputs hold; merging is commutative and idempotent.
+func MergeUpdates(updates ...[]byte) ([]byte, error) {
+	if len(updates) == 1 {
+		return updates[0], Validate(updates[0])
+	}
+	readers := make([]*blockReader, 0, len(updates))
+	dss := make([]deleteSet, 0, len(updates))
+	for _, u := range updates {
+		blocks, ds, err := decodeUpdate(u)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, newBlockReader(blocks, true))
+		dss = append(dss, ds)
+	}
+
+	var w blockWriter
+	var cw *block // struct waiting to be written
+	for {
+		// higher clients first, then by clock
+		live := readers[:0]
+		for _, r := range readers {
+			if r.curr() != nil {
+				live = append(live, r)
+			}
+		}
+		readers = live
+		if len(readers) == 0 {
+			break
+		}
+		sort.SliceStable(readers, func(i, j int) bool {
+			a, b := readers[i].curr(), readers[j].curr()
+			if a.id.Client != b.id.Client {
+				return a.id.Client > b.id.Client
+			}
+			if a.id.Clock != b.id.Clock {
+				return a.id.Clock < b.id.Clock
+			}
+			return a.kind != b.kind && a.kind != kindSkip
+		})
+		rd := readers[0]
+		first := rd.curr().id.Client
+
+		if cw != nil {
+			cur := rd.curr()
+			iterated := false
+			// skip what has been written already
+			for cur != nil && cur.id.Clock+cur.length <= cw.id.Clock+cw.length && cur.id.Client >= cw.id.Client {
+				cur = rd.next()
+				iterated = true
+			}
+			if cur == nil || cur.id.Client != first || (iterated && cur.id.Clock > cw.id.Clock+cw.length) {
+				continue
+			}
+
+			switch {
+			case first != cw.id.Client:
+				w.write(cw, 0)
+				cw = cur
+				rd.next()
+			case cw.id.Clock+cw.length < cur.id.Clock:
+				// gap in this client's clock: fill it with a skip
+				if cw.kind == kindSkip {
+					cw.length = cur.id.Clock + cur.length - cw.id.Clock
+				} else {
+					w.write(cw, 0)
+					diff := cur.id.Clock - cw.id.Clock - cw.length
+					cw = &block{kind: kindSkip, id: ID{Client: first, Clock: cw.id.Clock + cw.length}, length: diff}
+				}
+			default:
+				if diff := cw.id.Clock + cw.length - cur.id.Clock; diff > 0 {
+					if cw.kind == kindSkip {
+						// prefer slicing the skip, the other struct carries more information
+						cw.length -= diff
+					} else {
+						cur = cur.slice(diff)
+					}
+				}
+				if !cw.mergeWith(cur) {
+					w.write(cw, 0)
+					cw = cur
+					rd.next()
+				}
+			}
+		} else {
+			cw = rd.curr()
+			rd.next()
+		}
+
+		for next := rd.curr(); next != nil && next.id.Client == first && next.id.Clock == cw.id.Clock+cw.length && next.kind != kindSkip; next = rd.next() {
+			w.write(cw, 0)
+			cw = next
+		}
+	}
+	if cw != nil {
+		w.write(cw, 0)
+	}
+
+	var e encoder
+	w.finish(&e)
+	mergeDeleteSets(dss).write(&e)
+	return e.buf, nil
+}
+
+// mergeDeleteSets unions delete sets, sorting and coalescing each client's ranges
+func mergeDeleteSets(dss []deleteSet) deleteSet {
+	merged := deleteSet{}
+	for _, ds := range dss {
+		for c, rs := range ds {
+			merged[c] = append(merged[c], rs...)
+		}
+	}
+	for c, rs := range merged {
+		if len(rs) == 0 {
+			delete(merged, c)
+			continue
+		}
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].clock < rs[j].clock })
+		out := rs[:1]
+		for _, r := range rs[1:] {
+			l := &out[len(out)-1]
+			if l.clock+l.len >= r.clock {
+				if end := r.clock + r.len - l.clock; end > l.len {
+					l.len = end
+				}
+			} else {
+				out = append(out, r)
+			}
+		}
+		merged[c] = out
+	}
+	return merged
+}
@@ -0,0 +1,115 @@
+package yjs
+
+import "sort"
+
+// StateVector maps each client to the next clock it has not produced yet
+type StateVector map[uint64]uint64
+
+// DecodeStateVector parses an encoded state vector.
+// An empty input is the empty state vector.
+func DecodeStateVector(b []byte) (StateVector, error) {
+	sv := StateVector{}
+	if len(b) == 0 {
+		return sv, nil
+	}
+	d := newDecoder(b)
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		sv[client] = clock
+	}
+	return sv, nil
+}
+
+// Encode writes the state vector with clients in descending order like Yjs
+func (sv StateVector) Encode() []byte {
+	clients := make([]uint64, 0, len(sv))
+	for c := range sv {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	var e encoder
+	e.writeVarUint(uint64(len(clients)))
+	for _, c := range clients {
+		e.writeVarUint(c)
+		e.writeVarUint(sv[c])
+	}
+	return e.buf
+}
+
+// StateVectorFromUpdate computes the state vector of a document holding update.
+// Clients whose structs don't start at clock 0, or that contain gaps, only count
+// up to the first gap (same as Yjs' encodeStateVectorFromUpdate).
+func StateVectorFromUpdate(update []byte) (StateVector, error) {
+	blocks, _, err := decodeUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	sv := StateVector{}
+	stopped := map[uint64]bool{}
+	for _, b := range blocks {
+		c := b.id.Client
+		if stopped[c] {
+			continue
+		}
+		if b.kind == kindSkip || b.id.Clock != sv[c] {
+			stopped[c] = true
+			continue
+		}
+		sv[c] = b.id.Clock + b.length
+	}
+	for c, clock := range sv {
+		if clock == 0 {
+			delete(sv, c)
+		}
+	}
+	return sv, nil
+}
+
+// DiffUpdate returns the part of update that a peer with state vector sv is
+// missing. The delete set is always included in full.
+func DiffUpdate(update []byte, sv StateVector) ([]byte, error) {
+	blocks, ds, err := decodeUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	var w blockWriter
+	for i := 0; i < len(blocks); {
+		cur := blocks[i]
+		client := cur.id.Client
+		known := sv[client]
+		if cur.kind == kindSkip {
+			// the first written struct of a client must not be a skip
+			i++
+			continue
+		}
+		if cur.id.Clock+cur.length > known {
+			var offset uint64
+			if known > cur.id.Clock {
+				offset = known - cur.id.Clock
+			}
+			w.write(cur, offset)
+			for i++; i < len(blocks) && blocks[i].id.Client == client; i++ {
+				w.write(blocks[i], 0)
+			}
+			continue
+		}
+		for i < len(blocks) && blocks[i].id.Client == client && blocks[i].id.Clock+blocks[i].length <= known {
+			i++
+		}
+	}
+	var e encoder
+	w.finish(&e)
+	ds.write(&e)
+	return e.buf, nil
+}
@@ -0,0 +1,48 @@
+// Recomputes the golden outputs of update_test.go with JS Yjs:
+//   npm install yjs && node pkg/yjs/testdata/golden.mjs
+import * as Y from 'yjs'
+
+const hex = (s) => Uint8Array.from(s.split(' ').map((b) => parseInt(b, 16)))
+const show = (u) => Array.from(u, (b) => b.toString(16).padStart(2, '0')).join(' ')
+const sv = (entries) => Y.encodeStateVector(new Map(entries))
+
+const insertAB = hex('01 01 01 00 04 01 01 74 02 61 62 00')
+const appendC = hex('01 01 01 02 84 01 01 01 63 00')
+const deleteB = hex('00 01 01 01 01 01')
+const gc3 = hex('01 01 02 00 00 03 00')
+const gc2 = hex('01 01 02 03 00 02 00')
+const insertX = hex('01 01 02 05 04 01 01 74 01 78 00')
+const gcSkipX = hex('01 03 02 00 00 03 0a 02 04 01 01 74 01 78 00')
+const gcSkipXHighBits = hex('01 03 02 00 00 03 4a 02 04 01 01 74 01 78 00')
+
+// The inputs are hand-encoded, check they are what a doc would produce
+const doc = new Y.Doc()
+doc.clientID = 1
+const updates = []
+doc.on('update', (u) => updates.push(u))
+doc.getText('t').insert(0, 'ab')
+doc.getText('t').insert(2, 'c')
+doc.getText('t').delete(1, 1)
+console.log('doc insertAB', show(updates[0]), show(updates[0]) === show(insertAB))
+console.log('doc appendC ', show(updates[1]), show(updates[1]) === show(appendC))
+console.log('doc deleteB ', show(updates[2]), show(updates[2]) === show(deleteB))
+
+const merged = Y.mergeUpdates([insertAB, appendC])
+const withDelete = Y.mergeUpdates([insertAB, deleteB])
+console.log('merge consecutive items', show(merged))
+console.log('merge order independent', show(Y.mergeUpdates([appendC, insertAB])))
+console.log('merge delete set       ', show(withDelete))
+console.log('merge adjacent gcs     ', show(Y.mergeUpdates([gc3, gc2])))
+console.log('merge gap becomes skip ', show(Y.mergeUpdates([gc3, insertX])))
+
+console.log('diff empty state vector', show(Y.diffUpdate(merged, sv([]))))
+console.log('diff struct boundary   ', show(Y.diffUpdate(merged, sv([[1, 2]]))))
+console.log('diff splits an item    ', show(Y.diffUpdate(merged, sv([[1, 1]]))))
+console.log('diff keeps deletes     ', show(Y.diffUpdate(withDelete, sv([[1, 2]]))))
+console.log('diff skip not first    ', show(Y.diffUpdate(gcSkipX, sv([[2, 3]]))))
+console.log('diff gc and skip       ', show(Y.diffUpdate(gcSkipX, sv([]))))
+console.log('diff skip high bits    ', show(Y.diffUpdate(gcSkipXHighBits, sv([[2, 3]]))))
+
+for (const [name, u] of Object.entries({ insertAB, appendC, deleteB, gc3, gcSkipX, gcSkipXHighBits })) {
+  console.log('state vector', name, show(Y.encodeStateVectorFromUpdate(u)))
+}
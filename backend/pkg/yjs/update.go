@@ -0,0 +1,473 @@
+package yjs
+
+import "sort"
+
+// ID identifies a struct by the client that created it and its logical clock
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// struct ref numbers used in the info byte of the v1 update encoding
+const (
+	refGC   = 0
+	refSkip = 10
+
+	contentDeleted = 1
+	contentJSON    = 2
+	contentBinary  = 3
+	contentString  = 4
+	contentEmbed   = 5
+	contentFormat  = 6
+	contentType    = 7
+	contentAny     = 8
+	contentDoc     = 9
+
+	typeXMLElement = 3
+	typeXMLHook    = 5
+)
+
+const (
+	bitOrigin      = 0x80
+	bitRightOrigin = 0x40
+	bitParentSub   = 0x20
+	bitsContent    = 0x1f
+)
+
+type blockKind uint8
+
+const (
+	kindGC blockKind = iota
+	kindSkip
+	kindItem
+)
+
+// block is one decoded struct (GC, Skip or Item) of an update
+type block struct {
+	kind   blockKind
+	id     ID
+	length uint64
+
+	// item fields
+	origin      *ID
+	rightOrigin *ID
+	parentKey   *string // root type name when the parent is a top-level type
+	parentID    *ID     // parent item when nested
+	parentSub   *string
+	content     content
+}
+
+// content is an item's payload; only what's needed to split and re-encode it is kept
+type content struct {
+	ref     byte
+	deleted uint64   // contentDeleted length
+	str     []uint16 // contentString in UTF-16 units
+	elems   [][]byte // contentJSON / contentAny, one encoded element each
+	raw     []byte   // encoded body of single-unit content
+}
+
+func (c *content) length() uint64 {
+	switch c.ref {
+	case contentDeleted:
+		return c.deleted
+	case contentJSON, contentAny:
+		return uint64(len(c.elems))
+	case contentString:
+		return uint64(len(c.str))
+	default:
+		return 1
+	}
+}
+
+// splice truncates c to offset and returns the remainder
+func (c *content) splice(offset uint64) content {
+	right := content{ref: c.ref}
+	switch c.ref {
+	case contentDeleted:
+		right.deleted = c.deleted - offset
+		c.deleted = offset
+	case contentJSON, contentAny:
+		right.elems = c.elems[offset:]
+		c.elems = c.elems[:offset:offset]
+	case contentString:
+		right.str = append([]uint16(nil), c.str[offset:]...)
+		c.str = c.str[:offset:offset]
+		// never leave half of a surrogate pair on either side (same as Yjs)
+		if last := c.str[offset-1]; last >= 0xd800 && last <= 0xdbff {
+			c.str = append(c.str[:offset-1], 0xfffd)
+			right.str[0] = 0xfffd
+		}
+	}
+	return right
+}
+
+func (c *content) write(e *encoder, offset uint64) {
+	switch c.ref {
+	case contentDeleted:
+		e.writeVarUint(c.deleted - offset)
+	case contentJSON, contentAny:
+		e.writeVarUint(uint64(len(c.elems)) - offset)
+		for _, el := range c.elems[offset:] {
+			e.writeBytes(el)
+		}
+	case contentString:
+		e.writeVarString(fromUTF16(c.str[offset:]))
+	default:
+		e.writeBytes(c.raw)
+	}
+}
+
+func readContent(d *decoder, ref byte) (content, error) {
+	c := content{ref: ref}
+	start := d.pos
+	var err error
+	switch ref {
+	case contentDeleted:
+		c.deleted, err = d.readVarUint()
+	case contentJSON, contentAny:
+		var n uint64
+		if n, err = d.readVarUint(); err != nil {
+			return c, err
+		}
+		for i := uint64(0); i < n; i++ {
+			from := d.pos
+			if ref == contentJSON {
+				_, err = d.readVarBytes()
+			} else {
+				err = d.skipAny()
+			}
+			if err != nil {
+				return c, err
+			}
+			c.elems = append(c.elems, d.buf[from:d.pos])
+		}
+	case contentString:
+		var s string
+		s, err = d.readVarString()
+		c.str = toUTF16(s)
+	case contentBinary, contentEmbed:
+		_, err = d.readVarBytes()
+	case contentFormat:
+		if _, err = d.readVarBytes(); err == nil {
+			_, err = d.readVarBytes()
+		}
+	case contentType:
+		var t uint64
+		if t, err = d.readVarUint(); err == nil && (t == typeXMLElement || t == typeXMLHook) {
+			_, err = d.readVarBytes()
+		}
+	case contentDoc:
+		if _, err = d.readVarBytes(); err == nil {
+			err = d.skipAny()
+		}
+	default:
+		return c, ErrMalformed
+	}
+	if err != nil {
+		return c, err
+	}
+	if c.length() == 0 {
+		return c, ErrMalformed
+	}
+	switch ref {
+	case contentBinary, contentEmbed, contentFormat, contentType, contentDoc:
+		c.raw = d.buf[start:d.pos]
+	}
+	return c, nil
+}
+
+// write encodes the block, skipping the first offset clock units
+func (b *block) write(e *encoder, offset uint64) {
+	switch b.kind {
+	case kindGC:
+		e.writeUint8(refGC)
+		e.writeVarUint(b.length - offset)
+	case kindSkip:
+		e.writeUint8(refSkip)
+		e.writeVarUint(b.length - offset)
+	default:
+		origin := b.origin
+		if offset > 0 {
+			origin = &ID{Client: b.id.Client, Clock: b.id.Clock + offset - 1}
+		}
+		info := b.content.ref & bitsContent
+		if origin != nil {
+			info |= bitOrigin
+		}
+		if b.rightOrigin != nil {
+			info |= bitRightOrigin
+		}
+		if b.parentSub != nil {
+			info |= bitParentSub
+		}
+		e.writeUint8(info)
+		if origin != nil {
+			writeID(e, *origin)
+		}
+		if b.rightOrigin != nil {
+			writeID(e, *b.rightOrigin)
+		}
+		if origin == nil && b.rightOrigin == nil {
+			if b.parentKey != nil {
+				e.writeVarUint(1)
+				e.writeVarString(*b.parentKey)
+			} else {
+				e.writeVarUint(0)
+				writeID(e, *b.parentID)
+			}
+			if b.parentSub != nil {
+				e.writeVarString(*b.parentSub)
+			}
+		}
+		b.content.write(e, offset)
+	}
+}
+
+// slice returns the part of b starting diff clock units in
+func (b *block) slice(diff uint64) *block {
+	id := ID{Client: b.id.Client, Clock: b.id.Clock + diff}
+	if b.kind != kindItem {
+		return &block{kind: b.kind, id: id, length: b.length - diff}
+	}
+	right := b.content.splice(diff)
+	return &block{
+		kind:        kindItem,
+		id:          id,
+		length:      right.length(),
+		origin:      &ID{Client: b.id.Client, Clock: id.Clock - 1},
+		rightOrigin: b.rightOrigin,
+		parentKey:   b.parentKey,
+		parentID:    b.parentID,
+		parentSub:   b.parentSub,
+		content:     right,
+	}
+}
+
+func readID(d *decoder) (*ID, error) {
+	client, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return &ID{Client: client, Clock: clock}, nil
+}
+
+func writeID(e *encoder, id ID) {
+	e.writeVarUint(id.Client)
+	e.writeVarUint(id.Clock)
+}
+
+// readBlocks decodes the struct section of a v1 update in encoded order
+func readBlocks(d *decoder) ([]*block, error) {
+	sections, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	var out []*block
+	for i := uint64(0); i < sections; i++ {
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < n; j++ {
+			b, err := readBlock(d, ID{Client: client, Clock: clock})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b)
+			clock += b.length
+		}
+	}
+	return out, nil
+}
+
+func readBlock(d *decoder, id ID) (*block, error) {
+	info, err := d.readUint8()
+	if err != nil {
+		return nil, err
+	}
+	if info&bitsContent == refSkip || info&bitsContent == refGC {
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, ErrMalformed
+		}
+		kind := kindGC
+		if info&bitsContent == refSkip {
+			kind = kindSkip
+		}
+		return &block{kind: kind, id: id, length: n}, nil
+	}
+
+	b := &block{kind: kindItem, id: id}
+	if info&bitOrigin != 0 {
+		if b.origin, err = readID(d); err != nil {
+			return nil, err
+		}
+	}
+	if info&bitRightOrigin != 0 {
+		if b.rightOrigin, err = readID(d); err != nil {
+			return nil, err
+		}
+	}
+	if b.origin == nil && b.rightOrigin == nil {
+		isKey, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if isKey == 1 {
+			key, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			b.parentKey = &key
+		} else if b.parentID, err = readID(d); err != nil {
+			return nil, err
+		}
+		if info&bitParentSub != 0 {
+			sub, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			b.parentSub = &sub
+		}
+	}
+	if b.content, err = readContent(d, info&bitsContent); err != nil {
+		return nil, err
+	}
+	b.length = b.content.length()
+	return b, nil
+}
+
+// blockWriter groups consecutive blocks of the same client into sections,
+// mirroring Yjs' LazyStructWriter
+type blockWriter struct {
+	sections []section
+	cur      *section
+}
+
+type section struct {
+	client uint64
+	n      uint64
+	body   encoder
+}
+
+func (w *blockWriter) write(b *block, offset uint64) {
+	if w.cur != nil && w.cur.client != b.id.Client {
+		w.flush()
+	}
+	if w.cur == nil {
+		w.cur = &section{client: b.id.Client}
+		w.cur.body.writeVarUint(b.id.Client)
+		w.cur.body.writeVarUint(b.id.Clock + offset)
+	}
+	b.write(&w.cur.body, offset)
+	w.cur.n++
+}
+
+func (w *blockWriter) flush() {
+	if w.cur != nil {
+		w.sections = append(w.sections, *w.cur)
+		w.cur = nil
+	}
+}
+
+// finish writes all sections to e
+func (w *blockWriter) finish(e *encoder) {
+	w.flush()
+	e.writeVarUint(uint64(len(w.sections)))
+	for _, s := range w.sections {
+		e.writeVarUint(s.n)
+		e.writeBytes(s.body.buf)
+	}
+}
+
+// deleteSet maps clients to deleted clock ranges
+type deleteSet map[uint64][]delRange
+
+type delRange struct{ clock, len uint64 }
+
+func readDeleteSet(d *decoder) (deleteSet, error) {
+	ds := deleteSet{}
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		m, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < m; j++ {
+			clock, err := d.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			l, err := d.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			ds[client] = append(ds[client], delRange{clock: clock, len: l})
+		}
+	}
+	return ds, nil
+}
+
+// write encodes the delete set with clients in descending order like Yjs
+func (ds deleteSet) write(e *encoder) {
+	clients := make([]uint64, 0, len(ds))
+	for c := range ds {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	e.writeVarUint(uint64(len(clients)))
+	for _, c := range clients {
+		e.writeVarUint(c)
+		e.writeVarUint(uint64(len(ds[c])))
+		for _, r := range ds[c] {
+			e.writeVarUint(r.clock)
+			e.writeVarUint(r.len)
+		}
+	}
+}
+
+// decodeUpdate splits a v1 update into its structs and delete set.
+// An empty input is treated as the empty update.
+func decodeUpdate(update []byte) ([]*block, deleteSet, error) {
+	if len(update) == 0 {
+		return nil, deleteSet{}, nil
+	}
+	d := newDecoder(update)
+	blocks, err := readBlocks(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	ds, err := readDeleteSet(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blocks, ds, nil
+}
+
+// Validate reports whether update is a well-formed v1 update
+func Validate(update []byte) error {
+	_, _, err := decodeUpdate(update)
+	return err
+}
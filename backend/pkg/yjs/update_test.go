@@ -0,0 +1,124 @@
+package yjs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Golden vectors: the inputs are v1 updates, the outputs what Yjs' own
+// mergeUpdates, diffUpdate and encodeStateVectorFromUpdate return for them.
+// testdata/golden.mjs recomputes every output with JS Yjs.
+var (
+	// client 1 inserts "ab" into the root text "t"
+	insertAB = "01 01 01 00 04 01 01 74 02 61 62 00"
+	// client 1 appends "c" after "b"
+	appendC = "01 01 01 02 84 01 01 01 63 00"
+	// client 1 deletes "b"
+	deleteB = "00 01 01 01 01 01"
+	// client 2: a GC of 3, then one of 2 right after it
+	gc3 = "01 01 02 00 00 03 00"
+	gc2 = "01 01 02 03 00 02 00"
+	// client 2 inserts "x" at clock 5, after a gap
+	insertX = "01 01 02 05 04 01 01 74 01 78 00"
+	// gc3 and insertX merged: the gap is a Skip
+	gcSkipX = "01 03 02 00 00 03 0a 02 04 01 01 74 01 78 00"
+	// the same with the Skip's info byte carrying unused high bits, which
+	// Yjs ignores (info & 31)
+	gcSkipXHighBits = "01 03 02 00 00 03 4a 02 04 01 01 74 01 78 00"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMergeUpdatesGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want string
+	}{
+		{"consecutive items", []string{insertAB, appendC}, "01 02 01 00 04 01 01 74 02 61 62 84 01 01 01 63 00"},
+		{"order independent", []string{appendC, insertAB}, "01 02 01 00 04 01 01 74 02 61 62 84 01 01 01 63 00"},
+		{"delete set", []string{insertAB, deleteB}, "01 01 01 00 04 01 01 74 02 61 62 01 01 01 01 01"},
+		{"adjacent gcs merge", []string{gc3, gc2}, "01 01 02 00 00 05 00"},
+		{"gap becomes skip", []string{gc3, insertX}, gcSkipX},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in [][]byte
+			for _, u := range tt.in {
+				in = append(in, unhex(t, u))
+			}
+			got, err := MergeUpdates(in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := unhex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("MergeUpdates = % x, want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestDiffUpdateGolden(t *testing.T) {
+	merged := "01 02 01 00 04 01 01 74 02 61 62 84 01 01 01 63 00"
+	tests := []struct {
+		name   string
+		update string
+		sv     StateVector
+		want   string
+	}{
+		{"empty state vector", merged, StateVector{}, merged},
+		{"struct boundary", merged, StateVector{1: 2}, appendC},
+		{"splits an item", merged, StateVector{1: 1}, "01 02 01 01 84 01 00 01 62 84 01 01 01 63 00"},
+		{"up to date keeps deletes", "01 01 01 00 04 01 01 74 02 61 62 01 01 01 01 01", StateVector{1: 2}, deleteB},
+		{"skip not written first", gcSkipX, StateVector{2: 3}, insertX},
+		{"gc and skip", gcSkipX, StateVector{}, gcSkipX},
+		{"skip with high bits", gcSkipXHighBits, StateVector{2: 3}, insertX},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffUpdate(unhex(t, tt.update), tt.sv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := unhex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("DiffUpdate = % x, want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestStateVectorFromUpdateGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+		want   StateVector
+	}{
+		{"single item", insertAB, StateVector{1: 2}},
+		{"not from clock 0", appendC, StateVector{}},
+		{"deletes only", deleteB, StateVector{}},
+		{"gc", gc3, StateVector{2: 3}},
+		{"stops at skip", gcSkipX, StateVector{2: 3}},
+		{"skip with high bits", gcSkipXHighBits, StateVector{2: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StateVectorFromUpdate(unhex(t, tt.update))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StateVectorFromUpdate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -188,6 +188,12 @@ export class AppComponent implements OnInit, AfterViewInit {
     });
   }
 
+  // viewers and commenters can follow along but the server drops their edits
+  readOnly(): boolean {
+    const role = this.docs.find(d => d.id === this.docId)?.role;
+    return role === 'viewer' || role === 'commenter';
+  }
+
   // editor
   onInput(): void {
     this.sync.updateLocal(this.text);
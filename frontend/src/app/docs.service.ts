@@ -6,6 +6,13 @@ export type DocItem = {
   title: string;
   version: number;
   updatedAt: string; // ISO timestamp
+  role?: 'owner' | 'editor' | 'commenter' | 'viewer';
+};
+
+export type DocPresence = {
+  docId: string;
+  count: number; // distinct users with the doc open
+  users: { userId: string; name?: string; clients: number }[];
 };
 
 @Injectable({ providedIn: 'root' })
@@ -24,6 +31,11 @@ export class DocsService {
     return this.http.post<DocItem>(`${this.base}/api/docs`, { title });
   }
 
+  // Who has the doc open right now, without joining it
+  presence(id: string) {
+    return this.http.get<DocPresence>(`${this.base}/api/docs/${id}/presence`);
+  }
+
   // Get the raw Yjs doc snapshot as a Blob
   getBlob(id: string) {
     return this.http.get(`${this.base}/api/docs/${id}`, { responseType: 'blob' });
@@ -2,12 +2,21 @@ import { Injectable } from '@angular/core';
 import * as Y from 'yjs';
 import { Awareness } from 'y-protocols/awareness';
 import { encodeAwarenessUpdate, applyAwarenessUpdate } from 'y-protocols/awareness';
+import { AuthService } from './auth.service';
 
 // ---- wire protocol codes ----
 const MSG_UPDATE    = 1; // Yjs incremental
-const MSG_SYNC_REQ  = 2; // ask peers for full state
-const MSG_SYNC_RES  = 3; // full state as update
+const MSG_SYNC_REQ  = 2; // ask server/peers for missing state (payload: state vector)
+const MSG_SYNC_RES  = 3; // full/missing state as update (the server merges it, never stores it as-is)
 const MSG_AWARENESS = 4; // awareness update
+const MSG_CONTROL   = 5; // server control message (JSON)
+
+// ---- close codes ----
+const CLOSE_RESYNC = 4001; // we fell behind; reconnect and sync from scratch
+const CLOSE_UNSUPPORTED = 4002; // server doesn't speak our protocol version; retrying won't help
+
+// ---- protocol version (WebSocket subprotocol) ----
+const PROTOCOL = 'rtdocs.v1';
 
 type TextListener = (t: string) => void;
 type StatusListener = (s: string) => void;
@@ -31,6 +40,9 @@ export type Presence = {
 };
 type PresenceListener = (p: Presence[]) => void;
 
+// Read-only viewers get this list instead of everyone's awareness
+type Editor = { clientId: number; name?: string; color?: string };
+
 @Injectable({ providedIn: 'root' })
 export class WSSyncService {
   private ws?: WebSocket;
@@ -49,12 +61,16 @@ export class WSSyncService {
   private readonly reconnectDelay = 2000; // Increased from 1500ms
   private maxReconnectAttempts = 5;
   private reconnectAttempts = 0;
+  private plannedReconnectMs?: number; // set when the server announces it is going away
+  private editors: Editor[] = []; // only sent to viewers
+  private resume?: { docId: string; token: string; seq: number }; // lets a reconnect skip the full sync
+  private offline: Uint8Array[] = []; // local edits made while disconnected, sent on resume
   private connectionState: 'disconnected' | 'connecting' | 'connected' | 'reconnecting' = 'disconnected';
 
   private name = `user-${Math.floor(Math.random() * 1000)}`;
   private color = pickColor();
 
-  constructor() {
+  constructor(private auth: AuthService) {
     this.setupDocumentEvents();
   }
 
@@ -62,10 +78,12 @@ export class WSSyncService {
   private setupDocumentEvents() {
     // Broadcast local Yjs ops
     this.ydoc.on('update', (update: Uint8Array, origin: unknown) => {
-      if (origin === 'local') this.send(MSG_UPDATE, update);
+      if (origin === 'local') {
+        if (this.ws?.readyState === WebSocket.OPEN) this.send(MSG_UPDATE, update);
+        else this.offline.push(update);
+      }
       this.emitText();
       this.emitPresence();
-      this.scheduleSnapshot();
     });
 
     // Broadcast awareness changes
@@ -88,6 +106,8 @@ export class WSSyncService {
   resetDocument(): void {
     // Close existing websocket if any
     this.ws?.close();
+    this.resume = undefined; // the fresh doc needs the full state
+    this.offline = [];
     
     // Destroy the old document
     this.ydoc.destroy();
@@ -127,22 +147,56 @@ export class WSSyncService {
       // Determine WebSocket URL based on environment
       const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
       const host = window.location.host;
-      const wsUrl = `${protocol}//${host}/ws?docId=${encodeURIComponent(docId)}`;
+      let wsUrl = `${protocol}//${host}/ws?docId=${encodeURIComponent(docId)}`;
+      if (this.resume?.docId === docId) {
+        // Only the frames missed since the last seq are sent, if the server still has them
+        wsUrl += `&resume=${this.resume.token}&seq=${this.resume.seq}`;
+      }
       
-      this.ws = new WebSocket(wsUrl);
+      // Browsers can't send headers on the upgrade, so the JWT rides as a subprotocol
+      const token = this.auth.getToken();
+      const ws = new WebSocket(wsUrl, token ? [PROTOCOL, `bearer.${token}`] : [PROTOCOL]);
+      this.ws = ws;
       this.ws.binaryType = 'arraybuffer';
 
       this.ws.onopen = () => {
         this.connectionState = 'connected';
         this.reconnectAttempts = 0; // Reset on successful connection
         this.emitStatus('connected');
-        // Ask peers for full state, and announce my current awareness
-        this.send(MSG_SYNC_REQ, new Uint8Array(0));
+        // Announce my current awareness; syncing waits for the server's session
+        // frame, which says whether the missed frames were already replayed
         const u = encodeAwarenessUpdate(this.awareness, [this.ydoc.clientID]);
         this.send(MSG_AWARENESS, u);
       };
 
       this.ws.onclose = (event) => { 
+        if (this.ws !== ws) return; // superseded by a newer socket
+        if (this.plannedReconnectMs !== undefined) {
+          // Server restart: come back after the delay it picked for us
+          const delay = this.plannedReconnectMs;
+          this.plannedReconnectMs = undefined;
+          this.connectionState = 'reconnecting';
+          this.emitStatus('reconnecting');
+          clearTimeout(this.reconnectTimer);
+          this.reconnectTimer = setTimeout(() => {
+            this.reconnectTimer = undefined;
+            this.connect(this.lastDocId!);
+          }, delay);
+          return;
+        }
+        if (event.code === CLOSE_UNSUPPORTED) {
+          console.warn(`Server rejected protocol ${PROTOCOL}: ${event.reason}`);
+          this.connectionState = 'disconnected';
+          this.emitStatus('error');
+          return;
+        }
+        if (event.code === CLOSE_RESYNC && this.lastDocId) {
+          // The sync handshake on reconnect fills in whatever we missed
+          this.connectionState = 'disconnected';
+          this.resume = undefined;
+          this.connect(this.lastDocId);
+          return;
+        }
         if (this.connectionState === 'connected') {
           this.connectionState = 'reconnecting';
           this.emitStatus('reconnecting'); 
@@ -173,8 +227,11 @@ export class WSSyncService {
             break;
           }
           case MSG_SYNC_REQ: {
-            const full = Y.encodeStateAsUpdate(this.ydoc);
-            this.send(MSG_SYNC_RES, full);
+            // Empty state vector means "send everything"
+            const diff = payload.length > 0
+              ? Y.encodeStateAsUpdate(this.ydoc, payload)
+              : Y.encodeStateAsUpdate(this.ydoc);
+            this.send(MSG_SYNC_RES, diff);
             break;
           }
           case MSG_AWARENESS: {
@@ -182,6 +239,10 @@ export class WSSyncService {
             this.emitPresence();
             break;
           }
+          case MSG_CONTROL: {
+            this.handleControl(JSON.parse(new TextDecoder().decode(payload)));
+            break;
+          }
         }
       };
     } catch {
@@ -190,6 +251,45 @@ export class WSSyncService {
     }
   }
 
+  // Server-initiated control messages
+  private handleControl(msg: { type: string; version?: number; afterMs?: number; editors?: Editor[]; token?: string; seq?: number; resumed?: boolean }) {
+    if (msg.type === 'session' && msg.token && this.lastDocId) {
+      this.resume = { docId: this.lastDocId, token: msg.token, seq: msg.seq ?? 0 };
+      if (msg.resumed) {
+        // We have everything the server had; it only lacks our offline edits
+        if (this.offline.length > 0) this.send(MSG_UPDATE, Y.mergeUpdates(this.offline));
+      } else {
+        // Ask for whatever we're missing; the server's own request covers our edits
+        this.send(MSG_SYNC_REQ, Y.encodeStateVector(this.ydoc));
+      }
+      this.offline = [];
+      return;
+    }
+    if (msg.type === 'seq') {
+      if (this.resume) this.resume.seq = msg.seq ?? 0;
+      return;
+    }
+    if (msg.type === 'editors') {
+      this.editors = msg.editors ?? [];
+      this.emitPresence();
+      return;
+    }
+    if (msg.type === 'reconnect') {
+      // The server closes the socket right after this
+      this.plannedReconnectMs = msg.afterMs ?? this.reconnectDelay;
+      return;
+    }
+    if (msg.type === 'reset' && this.lastDocId) {
+      // Doc was restored to an older version: our state must not be merged back,
+      // so start from a fresh doc and let the server send the restored state
+      const docId = this.lastDocId;
+      this.connectionState = 'disconnected';
+      this.resetDocument();
+      this.emitText();
+      this.connect(docId);
+    }
+  }
+
   updateLocal(next: string): void {
     if (this.applyingRemote) return;
     const cur = this.ytext.toString();
@@ -227,6 +327,10 @@ export class WSSyncService {
       const head   = toAbs(this.ydoc, st?.cursor?.head   ?? null);
       peers.push({ clientId, name, color, anchor, head });
     });
+    for (const e of this.editors) {
+      if (states.has(e.clientId)) continue;
+      peers.push({ clientId: e.clientId, name: e.name ?? `user-${e.clientId}`, color: e.color ?? '#999', anchor: null, head: null });
+    }
     this.presenceListeners.forEach(cb => cb(peers));
   }
 
@@ -260,17 +364,6 @@ export class WSSyncService {
     }, delay);
   }
 
-  private snapshotTimer: any = null;
-
-  private scheduleSnapshot() {
-    clearTimeout(this.snapshotTimer);
-    this.snapshotTimer = setTimeout(() => {
-      const full = Y.encodeStateAsUpdate(this.ydoc);
-      // reuse the existing send() to prepend the 1-byte type (3)
-      this.send(MSG_SYNC_RES, full);
-  }, 250); // debounce window aligned with server-side 250ms saves
-  }
-
 }
 
 